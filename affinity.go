@@ -0,0 +1,67 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.design/x/bench/internal/cpupower"
+	"golang.design/x/bench/internal/lock"
+	"golang.design/x/bench/internal/term"
+)
+
+// benchCPUs are the CPUs reserved with -cpus, or nil for all CPUs.
+var benchCPUs []int
+
+// isolated reports whether bench was moved into the cpuset partition
+// of benchCPUs.
+var isolated bool
+
+// startPinned starts cmd restricted to the CPUs reserved with -cpus,
+// if any. In exclusive mode, the daemon c also isolates them from
+// other tasks if it is configured to.
+//
+// A command inherits the CPU affinity of the thread that starts it and
+// the cgroup of bench, so both are set up before it starts. This way
+// the processes it starts right away, such as the compiler and linker
+// run by go test, are restricted as well.
+func startPinned(c *lock.Client, cmd *exec.Cmd) error {
+	if benchCPUs == nil {
+		return cmd.Start()
+	}
+	if c != nil && !*flagShared && !isolated {
+		// bench itself mostly waits for the command, so it
+		// shares the partition rather than the other CPUs.
+		ok, err := c.Isolate(os.Getpid())
+		if err != nil {
+			log.Print(term.Orange(fmt.Sprintf("failed to isolate CPUs: %v", err)))
+		} else if ok {
+			isolated = true
+			resultLabels["isolated"] = "true"
+			log.Print(term.Gray(fmt.Sprintf("run benchmarks on isolated CPUs %s...", cpupower.FormatCPUList(benchCPUs))))
+		}
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	old, err := threadAffinity()
+	if err == nil {
+		err = setThreadAffinity(benchCPUs)
+	}
+	if err != nil {
+		log.Print(term.Orange(fmt.Sprintf("failed to set CPU affinity: %v", err)))
+		return cmd.Start()
+	}
+	err = cmd.Start()
+	if err1 := setThreadAffinity(old); err1 != nil {
+		// Do not let other goroutines run restricted.
+		log.Fatalf("failed to restore CPU affinity: %v", err1)
+	}
+	return err
+}
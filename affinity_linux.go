@@ -0,0 +1,52 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.design/x/bench/internal/cpupower"
+)
+
+// A cpuMask is a CPU affinity mask large enough for all CPUs Linux
+// supports.
+type cpuMask [cpupower.MaxCPUs / 64]uint64
+
+// threadAffinity returns the CPU affinity of the calling thread.
+func threadAffinity() ([]int, error) {
+	var mask cpuMask
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY,
+		0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return nil, fmt.Errorf("sched_getaffinity: %v", errno)
+	}
+	var cpus []int
+	for c := 0; c < cpupower.MaxCPUs; c++ {
+		if mask[c/64]&(1<<(uint(c)%64)) != 0 {
+			cpus = append(cpus, c)
+		}
+	}
+	return cpus, nil
+}
+
+// setThreadAffinity restricts the calling thread to cpus. Processes
+// it starts afterwards inherit the restriction.
+func setThreadAffinity(cpus []int) error {
+	var mask cpuMask
+	for _, c := range cpus {
+		mask[c/64] |= 1 << (uint(c) % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY,
+		0, uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return fmt.Errorf("sched_setaffinity: %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+var errAffinity = errors.New("CPU affinity is only supported on Linux")
+
+func threadAffinity() ([]int, error) {
+	return nil, errAffinity
+}
+
+func setThreadAffinity(cpus []int) error {
+	return errAffinity
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"golang.design/x/bench/internal/cpupower"
+	"golang.design/x/bench/internal/term"
+)
+
+// An energyMeter measures the energy consumed while each benchmark
+// runs, from the RAPL energy counters of the CPU packages, in J/op.
+// The counters cover everything running on the packages, not just the
+// benchmark.
+type energyMeter struct {
+	counters []*cpupower.EnergyCounter
+	readings []uint64 // at the start of the current benchmark
+}
+
+// energyUnreadable records that the unreadable energy counters were
+// reported, so that later runs do not report them again.
+var energyUnreadable bool
+
+// newEnergyMeter returns an energy meter, or nil if the host has no
+// readable RAPL energy counters.
+func newEnergyMeter() *energyMeter {
+	counters, err := cpupower.Host().EnergyCounters()
+	if err != nil || len(counters) == 0 {
+		return nil
+	}
+	m := &energyMeter{counters: counters}
+	if _, err := m.read(); err != nil {
+		if !energyUnreadable {
+			energyUnreadable = true
+			log.Print(term.Gray(fmt.Sprintf("run benchmarks without energy measurement: %v", err)))
+		}
+		return nil
+	}
+	return m
+}
+
+// read returns the current readings of all counters.
+func (m *energyMeter) read() ([]uint64, error) {
+	readings := make([]uint64, len(m.counters))
+	for i, c := range m.counters {
+		v, err := c.Energy()
+		if err != nil {
+			return nil, err
+		}
+		readings[i] = v
+	}
+	return readings, nil
+}
+
+func (m *energyMeter) start() {
+	m.readings, _ = m.read()
+}
+
+func (m *energyMeter) stop(n int) []string {
+	end, err := m.read()
+	if err != nil || m.readings == nil {
+		return nil
+	}
+	var uj uint64
+	for i, c := range m.counters {
+		uj += c.Delta(m.readings[i], end[i])
+	}
+	return []string{strconv.FormatFloat(float64(uj)/1e6/float64(n), 'g', 4, 64) + " J/op"}
+}
@@ -1,3 +1,3 @@
 module golang.design/x/bench
 
-go 1.15
+go 1.16
@@ -26,9 +26,9 @@ if [[ -d /etc/init ]]; then
 fi
 if [[ -d /etc/systemd ]]; then
     echo "Installing service for systemd" 1>&2
-    sudo install -m 0644 init/systemd/bench.service /etc/systemd/system
-    sudo systemctl enable --quiet bench.service
-    start="systemctl start bench.service"
+    sudo install -m 0644 init/systemd/bench.socket init/systemd/bench.service /etc/systemd/system
+    sudo systemctl enable --quiet bench.socket bench.service
+    start="systemctl start bench.socket bench.service"
     starttype=" (using systemd)"
 fi
 
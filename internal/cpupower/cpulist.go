@@ -0,0 +1,63 @@
+package cpupower
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxCPUs is the largest number of CPUs Linux supports. CPU lists
+// naming higher CPUs are rejected before they are expanded.
+const MaxCPUs = 8192
+
+// ParseCPUList parses a list of CPUs in the format used by sysfs,
+// such as "0-3,8,10-11". The returned CPUs are sorted and unique.
+func ParseCPUList(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	seen := make(map[int]bool)
+	var cpus []int
+	for _, r := range strings.Split(s, ",") {
+		lo, hi := r, r
+		if i := strings.Index(r, "-"); i >= 0 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		first, err1 := strconv.Atoi(lo)
+		last, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || first < 0 || first > last {
+			return nil, fmt.Errorf("invalid CPU list %q", s)
+		}
+		if last >= MaxCPUs {
+			return nil, fmt.Errorf("invalid CPU list %q: CPU %d out of range", s, last)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// FormatCPUList formats the sorted CPUs in the format used by sysfs.
+func FormatCPUList(cpus []int) string {
+	var ranges []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(cpus[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
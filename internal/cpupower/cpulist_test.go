@@ -0,0 +1,55 @@
+package cpupower
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+		ok   bool
+	}{
+		{"", nil, true},
+		{"0", []int{0}, true},
+		{"0-3", []int{0, 1, 2, 3}, true},
+		{"0-3,8,10-11\n", []int{0, 1, 2, 3, 8, 10, 11}, true},
+		{"4,0-1,1", []int{0, 1, 4}, true},
+		{"8191", []int{8191}, true},
+		{"8192", nil, false},
+		{"0-4000000000", nil, false},
+		{"3-1", nil, false},
+		{"-1", nil, false},
+		{"0,,1", nil, false},
+		{"a-b", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPUList(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseCPUList(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCPUList(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCPUList(t *testing.T) {
+	tests := []struct {
+		in   []int
+		want string
+	}{
+		{nil, ""},
+		{[]int{0}, "0"},
+		{[]int{0, 1, 2, 3}, "0-3"},
+		{[]int{0, 1, 2, 3, 8, 10, 11}, "0-3,8,10-11"},
+		{[]int{1, 3, 5}, "1,3,5"},
+	}
+	for _, tt := range tests {
+		if got := FormatCPUList(tt.in); got != tt.want {
+			t.Errorf("FormatCPUList(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
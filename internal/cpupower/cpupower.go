@@ -1,10 +1,13 @@
-// Package cpupower manipulates Linux CPU frequency scaling settings.
+// Package cpupower manipulates Linux CPU frequency scaling, SMT and idle
+// state settings.
 package cpupower
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -12,34 +15,89 @@ import (
 	"strings"
 )
 
+// A System is a sysfs tree exposing CPU frequency scaling settings.
+type System struct {
+	fs FS
+}
+
+// FS is a sysfs tree. Names are slash-separated paths relative to
+// the sysfs mount point, such as "devices/system/cpu/cpu0/cpufreq".
+type FS interface {
+	fs.FS
+
+	// WriteFile writes data to the existing file name.
+	WriteFile(name string, data []byte) error
+}
+
+// New returns the System backed by the sysfs tree fsys.
+func New(fsys FS) *System {
+	return &System{fsys}
+}
+
+// Host returns the System of this host, backed by /sys.
+func Host() *System {
+	return New(DirFS("/sys"))
+}
+
+// DirFS returns an FS for the sysfs tree rooted at dir.
+func DirFS(dir string) FS {
+	return dirFS{os.DirFS(dir), dir}
+}
+
+type dirFS struct {
+	fs.FS
+	dir string
+}
+
+func (d dirFS) WriteFile(name string, data []byte) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	// Never create name, so that writing a missing sysfs attribute
+	// reports fs.ErrNotExist. Truncating has no effect on sysfs, but
+	// keeps trees of regular files consistent.
+	f, err := os.OpenFile(filepath.Join(d.dir, filepath.FromSlash(name)), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+const cpuDir = "devices/system/cpu"
+
 // Domain is a frequency scaling domain. This may include more than
 // one CPU.
 type Domain struct {
+	sys       *System
 	path      string
+	cpus      []int
 	min, max  int
 	available []int
 }
 
 var cpuRe = regexp.MustCompile(`cpu\d+$`)
 
-// Domains returns the frequency scaling domains of this host.
-func Domains() ([]*Domain, error) {
-	dir := "/sys/devices/system/cpu"
-	fs, err := ioutil.ReadDir(dir)
+// Domains returns the frequency scaling domains of this system.
+func (s *System) Domains() ([]*Domain, error) {
+	entries, err := fs.ReadDir(s.fs, cpuDir)
 	if err != nil {
 		return nil, err
 	}
 
 	var domains []*Domain
 	haveDomains := make(map[string]bool)
-	for _, f := range fs {
+	for _, f := range entries {
 		if !f.IsDir() || !cpuRe.MatchString(f.Name()) {
 			continue
 		}
-		pdir := filepath.Join(dir, f.Name(), "cpufreq")
+		pdir := path.Join(cpuDir, f.Name(), "cpufreq")
 
 		// Get the frequency domain, if any.
-		cpus, err := ioutil.ReadFile(filepath.Join(pdir, "freqdomain_cpus"))
+		cpus, err := fs.ReadFile(s.fs, path.Join(pdir, "freqdomain_cpus"))
 		if err == nil {
 			if haveDomains[string(cpus)] {
 				// We already have a CPU in this domain.
@@ -50,24 +108,77 @@ func Domains() ([]*Domain, error) {
 			return nil, err
 		}
 
-		min, err := readInt(filepath.Join(pdir, "cpuinfo_min_freq"))
-		if err != nil {
+		min, err := s.readInt(path.Join(pdir, "cpuinfo_min_freq"))
+		if os.IsNotExist(err) {
+			// An offline CPU, or one without frequency scaling.
+			continue
+		} else if err != nil {
 			return nil, err
 		}
-		max, err := readInt(filepath.Join(pdir, "cpuinfo_max_freq"))
+		max, err := s.readInt(path.Join(pdir, "cpuinfo_max_freq"))
 		if err != nil {
 			return nil, err
 		}
-		avail, err := readInts(filepath.Join(pdir, "scaling_available_frequencies"))
+		avail, err := s.readInts(path.Join(pdir, "scaling_available_frequencies"))
 		if err != nil && !os.IsNotExist(err) {
 			return nil, err
 		}
 		sort.Ints(avail)
-		domains = append(domains, &Domain{pdir, min, max, avail})
+
+		// Get the CPUs this domain covers, from the most specific
+		// source available.
+		cpuList, err := s.readString(path.Join(pdir, "related_cpus"))
+		if os.IsNotExist(err) {
+			cpuList, err = string(cpus), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(cpuList) == "" {
+			cpuList = strings.TrimPrefix(f.Name(), "cpu")
+		}
+		domainCPUs, err := ParseCPUList(cpuList)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", pdir, err)
+		}
+
+		domains = append(domains, &Domain{s, pdir, domainCPUs, min, max, avail})
 	}
 	return domains, nil
 }
 
+// PresentCPUs returns the CPUs present in this system, online or not.
+func (s *System) PresentCPUs() ([]int, error) {
+	list, err := s.readString(path.Join(cpuDir, "present"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseCPUList(list)
+}
+
+// Path returns the cpufreq directory of this domain relative to the
+// root of its System, which identifies it across calls to Domains.
+func (d *Domain) Path() string {
+	return d.path
+}
+
+// CPUs returns the CPUs in this domain, sorted.
+func (d *Domain) CPUs() []int {
+	return d.cpus
+}
+
+// Covers reports whether this domain includes any of cpus.
+func (d *Domain) Covers(cpus []int) bool {
+	for _, c := range d.cpus {
+		for _, x := range cpus {
+			if c == x {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // AvailableRange returns the available frequency range this CPU is
 // capable of and the set of available frequencies in ascending order
 // or nil if any frequency can be set.
@@ -75,14 +186,39 @@ func (d *Domain) AvailableRange() (int, int, []int) {
 	return d.min, d.max, d.available
 }
 
+// TargetFreq returns the frequency percent of the way between the
+// lowest and highest frequencies this CPU is capable of, rounded to
+// the nearest available frequency.
+func (d *Domain) TargetFreq(percent int) int {
+	abs := func(x int) int {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	target := (d.max-d.min)*percent/100 + d.min
+
+	// Find the nearest available frequency.
+	if len(d.available) != 0 {
+		closest := d.available[0]
+		for _, a := range d.available {
+			if abs(target-a) < abs(target-closest) {
+				closest = a
+			}
+		}
+		target = closest
+	}
+	return target
+}
+
 // CurrentRange returns the current frequency range this CPU's
 // governor can select between.
 func (d *Domain) CurrentRange() (int, int, error) {
-	min, err := readInt(filepath.Join(d.path, "scaling_min_freq"))
+	min, err := d.sys.readInt(path.Join(d.path, "scaling_min_freq"))
 	if err != nil {
 		return 0, 0, err
 	}
-	max, err := readInt(filepath.Join(d.path, "scaling_max_freq"))
+	max, err := d.sys.readInt(path.Join(d.path, "scaling_max_freq"))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -95,30 +231,69 @@ func (d *Domain) SetRange(min, max int) error {
 	// Attempting to set an empty range will cause an IO error.
 	// Rather than trying to figure out the right order to set
 	// them in, try both orders.
-	err1 := writeInt(filepath.Join(d.path, "scaling_min_freq"), min)
-	if err2 := writeInt(filepath.Join(d.path, "scaling_max_freq"), max); err2 != nil {
+	err1 := d.sys.writeInt(path.Join(d.path, "scaling_min_freq"), min)
+	if err2 := d.sys.writeInt(path.Join(d.path, "scaling_max_freq"), max); err2 != nil {
 		return err2
 	}
 	if err1 != nil {
-		err1 = writeInt(filepath.Join(d.path, "scaling_min_freq"), min)
+		err1 = d.sys.writeInt(path.Join(d.path, "scaling_min_freq"), min)
 	}
 	return err1
 }
 
-func readInt(path string) (int, error) {
-	data, err := ioutil.ReadFile(path)
+// Governor returns the name of the current scaling governor of this
+// CPU.
+func (d *Domain) Governor() (string, error) {
+	return d.sys.readString(path.Join(d.path, "scaling_governor"))
+}
+
+// AvailableGovernors returns the names of the scaling governors this
+// CPU supports.
+func (d *Domain) AvailableGovernors() ([]string, error) {
+	data, err := fs.ReadFile(d.sys.fs, path.Join(d.path, "scaling_available_governors"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// SetGovernor sets the scaling governor of this CPU.
+func (d *Domain) SetGovernor(name string) error {
+	return d.sys.writeString(path.Join(d.path, "scaling_governor"), name)
+}
+
+// SetSpeed sets the frequency of this CPU. It only has an effect
+// under the userspace governor.
+func (d *Domain) SetSpeed(freq int) error {
+	return d.sys.writeInt(path.Join(d.path, "scaling_setspeed"), freq)
+}
+
+func (s *System) readInt(name string) (int, error) {
+	data, err := fs.ReadFile(s.fs, name)
 	if err != nil {
 		return 0, err
 	}
 	return strconv.Atoi(strings.TrimSpace(string(data)))
 }
 
-func writeInt(path string, val int) error {
-	return ioutil.WriteFile(path, []byte(fmt.Sprintf("%d", val)), 0)
+func (s *System) writeInt(name string, val int) error {
+	return s.writeString(name, fmt.Sprintf("%d", val))
+}
+
+func (s *System) readString(name string) (string, error) {
+	data, err := fs.ReadFile(s.fs, name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *System) writeString(name, val string) error {
+	return s.fs.WriteFile(name, []byte(val))
 }
 
-func readInts(path string) ([]int, error) {
-	data, err := ioutil.ReadFile(path)
+func (s *System) readInts(name string) ([]int, error) {
+	data, err := fs.ReadFile(s.fs, name)
 	if err != nil {
 		return nil, err
 	}
@@ -132,3 +307,48 @@ func readInts(path string) ([]int, error) {
 	}
 	return ints, nil
 }
+
+// ErrNoBoost is returned by Boost and SetBoost if frequency boosting
+// cannot be controlled on this host.
+var ErrNoBoost = errors.New("cpupower: boost control not supported")
+
+// Files controlling frequency boosting (turbo). intel_pstate exposes
+// the inverse setting.
+const (
+	noTurboPath = "devices/system/cpu/intel_pstate/no_turbo"
+	boostPath   = "devices/system/cpu/cpufreq/boost"
+)
+
+// Boost reports whether frequency boosting (turbo) is enabled.
+func (s *System) Boost() (bool, error) {
+	v, err := s.readInt(noTurboPath)
+	if err == nil {
+		return v == 0, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	v, err = s.readInt(boostPath)
+	if err == nil {
+		return v != 0, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	return false, ErrNoBoost
+}
+
+// SetBoost enables or disables frequency boosting (turbo).
+func (s *System) SetBoost(enabled bool) error {
+	b := 0
+	if enabled {
+		b = 1
+	}
+	err := s.writeInt(noTurboPath, 1-b)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	err = s.writeInt(boostPath, b)
+	if os.IsNotExist(err) {
+		return ErrNoBoost
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package cpupower_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.design/x/bench/internal/cpupower"
+	"golang.design/x/bench/internal/cpupower/cpupowertest"
+)
+
+func TestDomains(t *testing.T) {
+	fsys := cpupowertest.New(
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Domain: "0-1"},
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Domain: "0-1"},
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 2000000, Domain: "2-3"},
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 2000000, Domain: "2-3"},
+	)
+	domains, err := cpupower.New(fsys).Domains()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]int
+	for _, d := range domains {
+		got = append(got, d.CPUs())
+	}
+	want := [][]int{{0, 1}, {2, 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("domain CPUs = %v, want %v", got, want)
+	}
+	if _, max, _ := domains[1].AvailableRange(); max != 2000000 {
+		t.Errorf("max frequency of second domain = %d, want 2000000", max)
+	}
+}
+
+func TestTargetFreq(t *testing.T) {
+	tests := []struct {
+		available []int
+		percent   int
+		want      int
+	}{
+		{nil, 0, 800000},
+		{nil, 50, 1900000},
+		{nil, 100, 3000000},
+		{[]int{800000, 1600000, 2400000, 3000000}, 0, 800000},
+		{[]int{800000, 1600000, 2400000, 3000000}, 50, 1600000},
+		{[]int{800000, 1600000, 2400000, 3000000}, 70, 2400000},
+		{[]int{800000, 1600000, 2400000, 3000000}, 100, 3000000},
+	}
+	for _, tt := range tests {
+		fsys := cpupowertest.New(cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Available: tt.available})
+		domains, err := cpupower.New(fsys).Domains()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := domains[0].TargetFreq(tt.percent); got != tt.want {
+			t.Errorf("TargetFreq(%d) with available %v = %d, want %d", tt.percent, tt.available, got, tt.want)
+		}
+	}
+}
+
+func TestSetRange(t *testing.T) {
+	const (
+		minPath = "devices/system/cpu/cpu0/cpufreq/scaling_min_freq"
+		maxPath = "devices/system/cpu/cpu0/cpufreq/scaling_max_freq"
+	)
+	tests := []struct {
+		name string
+		from [2]int
+		to   [2]int
+		want []cpupowertest.Write
+	}{
+		{
+			name: "lower",
+			from: [2]int{800000, 3000000},
+			to:   [2]int{1000000, 1000000},
+			want: []cpupowertest.Write{{Name: minPath, Data: "1000000"}, {Name: maxPath, Data: "1000000"}},
+		},
+		{
+			// The minimum cannot be raised above the old
+			// maximum, so it is written again after it.
+			name: "raise",
+			from: [2]int{1000000, 1000000},
+			to:   [2]int{2000000, 2000000},
+			want: []cpupowertest.Write{{Name: maxPath, Data: "2000000"}, {Name: minPath, Data: "2000000"}},
+		},
+		{
+			name: "restore",
+			from: [2]int{2000000, 2000000},
+			to:   [2]int{800000, 3000000},
+			want: []cpupowertest.Write{{Name: minPath, Data: "800000"}, {Name: maxPath, Data: "3000000"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := cpupowertest.New(cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000})
+			domains, err := cpupower.New(fsys).Domains()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := domains[0]
+			if err := d.SetRange(tt.from[0], tt.from[1]); err != nil {
+				t.Fatal(err)
+			}
+			before := len(fsys.Writes())
+			if err := d.SetRange(tt.to[0], tt.to[1]); err != nil {
+				t.Fatal(err)
+			}
+			if got := fsys.Writes()[before:]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writes = %v, want %v", got, tt.want)
+			}
+			if min, max, err := d.CurrentRange(); err != nil || min != tt.to[0] || max != tt.to[1] {
+				t.Errorf("CurrentRange() = %d, %d, %v, want %d, %d", min, max, err, tt.to[0], tt.to[1])
+			}
+		})
+	}
+}
+
+func TestMaxTemperature(t *testing.T) {
+	fsys := cpupowertest.New(cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000})
+	sys := cpupower.New(fsys)
+	if _, err := sys.MaxTemperature(); !os.IsNotExist(err) {
+		t.Errorf("MaxTemperature() without thermal zones: error %v, want not exist", err)
+	}
+
+	fsys.AddThermalZone(0, 45000)
+	fsys.AddThermalZone(1, 71500)
+	// Some zones report bogus readings.
+	fsys.AddThermalZone(2, -273000)
+	if temp, err := sys.MaxTemperature(); err != nil || temp != 71.5 {
+		t.Errorf("MaxTemperature() = %v, %v, want 71.5", temp, err)
+	}
+}
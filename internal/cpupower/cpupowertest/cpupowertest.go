@@ -0,0 +1,371 @@
+// Package cpupowertest provides fake sysfs CPU trees, so that code
+// built on package cpupower can be exercised without root or real
+// frequency scaling hardware.
+//
+//	fsys := cpupowertest.New(
+//		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Domain: "0-1"},
+//		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Domain: "0-1"},
+//	)
+//	domains, err := cpupower.New(fsys).Domains()
+//	...
+//	for _, w := range fsys.Writes() {
+//		...
+//	}
+package cpupowertest
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing/fstest"
+)
+
+const cpuDir = "devices/system/cpu"
+
+// CPU describes a CPU of a fake sysfs tree. Zero fields take the
+// defaults documented below.
+type CPU struct {
+	// MinFreq and MaxFreq are the frequency range the CPU is
+	// capable of, in kHz (cpuinfo_min_freq and cpuinfo_max_freq).
+	// The current range is initially the full range.
+	MinFreq, MaxFreq int
+	// Available lists the available frequencies in kHz
+	// (scaling_available_frequencies). If nil, the file is absent,
+	// as with drivers that accept any frequency.
+	Available []int
+	// Domain is the list of CPUs sharing the frequency domain, such
+	// as "0-3" (freqdomain_cpus). If empty, the file is absent.
+	Domain string
+	// Driver is the scaling driver. It defaults to "acpi-cpufreq".
+	Driver string
+	// Governor is the current scaling governor and Governors the
+	// available ones. They default to "schedutil" and
+	// "performance powersave schedutil userspace".
+	Governor  string
+	Governors []string
+	// EPP is the energy performance preference and EPPs the
+	// available ones. If EPPs is nil, the files are absent.
+	EPP  string
+	EPPs []string
+	// Siblings is the list of CPUs sharing the core, such as "0,4"
+	// (topology/thread_siblings_list). If empty, the CPU is the
+	// only one in its core.
+	Siblings string
+	// IdleStates are the idle states of the CPU, from the
+	// shallowest to the deepest, all initially enabled.
+	IdleStates []IdleState
+}
+
+// IdleState describes an idle state of a CPU.
+type IdleState struct {
+	Name    string
+	Latency int // exit latency in µs
+}
+
+// A Write records a single write to the fake tree.
+type Write struct {
+	Name, Data string
+}
+
+// FS is a fake sysfs tree implementing cpupower.FS. Like the kernel,
+// it rejects writes to missing files and writes that would make a
+// frequency range empty, and it keeps scaling_cur_freq within the
+// current range. It is safe for concurrent use.
+type FS struct {
+	mu     sync.Mutex
+	files  fstest.MapFS
+	writes []Write
+}
+
+// New returns a fake sysfs tree with the given CPUs, numbered from 0.
+func New(cpus ...CPU) *FS {
+	f := &FS{files: make(fstest.MapFS)}
+	for i, c := range cpus {
+		f.AddCPU(i, c)
+	}
+	return f
+}
+
+// AddCPU adds CPU number n described by c to the tree. The CPUs up to
+// the highest one added are present.
+func (f *FS) AddCPU(n int, c CPU) {
+	if c.Driver == "" {
+		c.Driver = "acpi-cpufreq"
+	}
+	if c.Governor == "" {
+		c.Governor = "schedutil"
+	}
+	if c.Governors == nil {
+		c.Governors = []string{"performance", "powersave", "schedutil", "userspace"}
+	}
+
+	dir := path.Join(cpuDir, fmt.Sprintf("cpu%d", n), "cpufreq")
+	f.SetFile(path.Join(dir, "cpuinfo_min_freq"), strconv.Itoa(c.MinFreq))
+	f.SetFile(path.Join(dir, "cpuinfo_max_freq"), strconv.Itoa(c.MaxFreq))
+	f.SetFile(path.Join(dir, "scaling_min_freq"), strconv.Itoa(c.MinFreq))
+	f.SetFile(path.Join(dir, "scaling_max_freq"), strconv.Itoa(c.MaxFreq))
+	f.SetFile(path.Join(dir, "scaling_cur_freq"), strconv.Itoa(c.MaxFreq))
+	f.SetFile(path.Join(dir, "scaling_setspeed"), "<unsupported>")
+	f.SetFile(path.Join(dir, "scaling_driver"), c.Driver)
+	f.SetFile(path.Join(dir, "scaling_governor"), c.Governor)
+	f.SetFile(path.Join(dir, "scaling_available_governors"), strings.Join(c.Governors, " "))
+	if c.Available != nil {
+		var avail []string
+		for _, a := range c.Available {
+			avail = append(avail, strconv.Itoa(a))
+		}
+		f.SetFile(path.Join(dir, "scaling_available_frequencies"), strings.Join(avail, " "))
+	}
+	if c.Domain != "" {
+		f.SetFile(path.Join(dir, "freqdomain_cpus"), c.Domain)
+	}
+	if c.EPPs != nil {
+		f.SetFile(path.Join(dir, "energy_performance_preference"), c.EPP)
+		f.SetFile(path.Join(dir, "energy_performance_available_preferences"), strings.Join(c.EPPs, " "))
+	}
+
+	f.mu.Lock()
+	last := -1
+	if present := f.file(path.Join(cpuDir, "present")); present != "" {
+		last, _ = strconv.Atoi(present[strings.LastIndex(present, "-")+1:])
+	}
+	f.mu.Unlock()
+	if n > last {
+		present := "0"
+		if n > 0 {
+			present = fmt.Sprintf("0-%d", n)
+		}
+		f.SetFile(path.Join(cpuDir, "present"), present)
+	}
+
+	// As on most systems, cpu0 cannot be taken offline.
+	dir = path.Dir(dir)
+	if n != 0 {
+		f.SetFile(path.Join(dir, "online"), "1")
+	}
+	if c.Siblings == "" {
+		c.Siblings = strconv.Itoa(n)
+	}
+	f.SetFile(path.Join(dir, "topology", "thread_siblings_list"), c.Siblings)
+	for i, st := range c.IdleStates {
+		sdir := path.Join(dir, "cpuidle", fmt.Sprintf("state%d", i))
+		f.SetFile(path.Join(sdir, "name"), st.Name)
+		f.SetFile(path.Join(sdir, "latency"), strconv.Itoa(st.Latency))
+		f.SetFile(path.Join(sdir, "disable"), "0")
+	}
+}
+
+// AddIntelPState adds the global intel_pstate settings to the tree,
+// with the given status ("active" or "passive"), performance limits
+// and no_turbo setting.
+func (f *FS) AddIntelPState(status string, minPct, maxPct int, noTurbo bool) {
+	dir := path.Join(cpuDir, "intel_pstate")
+	f.SetFile(path.Join(dir, "status"), status)
+	f.SetFile(path.Join(dir, "min_perf_pct"), strconv.Itoa(minPct))
+	f.SetFile(path.Join(dir, "max_perf_pct"), strconv.Itoa(maxPct))
+	f.SetFile(path.Join(dir, "no_turbo"), boolString(noTurbo))
+}
+
+// AddSMTControl adds the global SMT control setting to the tree, such
+// as "on".
+func (f *FS) AddSMTControl(control string) {
+	f.SetFile(path.Join(cpuDir, "smt", "control"), control)
+}
+
+// AddEnergyCounter adds the RAPL energy counter of CPU package n to
+// the tree, starting at zero. Use SetFile to advance it.
+func (f *FS) AddEnergyCounter(n int, maxRange uint64) {
+	dir := fmt.Sprintf("class/powercap/intel-rapl:%d", n)
+	f.SetFile(path.Join(dir, "name"), fmt.Sprintf("package-%d", n))
+	f.SetFile(path.Join(dir, "energy_uj"), "0")
+	f.SetFile(path.Join(dir, "max_energy_range_uj"), strconv.FormatUint(maxRange, 10))
+}
+
+// AddThermalZone adds thermal zone n to the tree, at temp in
+// millidegrees Celsius.
+func (f *FS) AddThermalZone(n, temp int) {
+	f.SetFile(fmt.Sprintf("class/thermal/thermal_zone%d/temp", n), strconv.Itoa(temp))
+}
+
+// AddBoost adds the generic cpufreq boost setting to the tree.
+func (f *FS) AddBoost(enabled bool) {
+	f.SetFile(path.Join(cpuDir, "cpufreq", "boost"), boolString(enabled))
+}
+
+func boolString(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// SetFile sets the content of the named file, creating it if needed.
+// It is not recorded as a write.
+func (f *FS) SetFile(name, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = &fstest.MapFile{Data: []byte(content + "\n"), Mode: 0644}
+}
+
+// File returns the content of the named file without the trailing
+// newline, or "" if it does not exist.
+func (f *FS) File(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file(name)
+}
+
+func (f *FS) file(name string) string {
+	if file := f.files[name]; file != nil {
+		return strings.TrimSuffix(string(file.Data), "\n")
+	}
+	return ""
+}
+
+// Writes returns the writes made to the tree, in order.
+func (f *FS) Writes() []Write {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Write(nil), f.writes...)
+}
+
+// Files returns the names of all files in the tree, sorted.
+func (f *FS) Files() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open implements fs.FS.
+func (f *FS) Open(name string) (fs.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Opened files share their data with the tree, so open a copy
+	// of it to be safe against concurrent writes.
+	files := make(fstest.MapFS, len(f.files))
+	for k, v := range f.files {
+		c := *v
+		files[k] = &c
+	}
+	return files.Open(name)
+}
+
+// WriteFile implements cpupower.FS.
+func (f *FS) WriteFile(name string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file := f.files[name]
+	if file == nil {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+	}
+	val := strings.TrimSpace(string(data))
+	if err := f.check(name, val); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	f.writes = append(f.writes, Write{name, val})
+	file.Data = []byte(val + "\n")
+	f.update(name)
+	return nil
+}
+
+// check reports whether writing val to name would be rejected by the
+// kernel.
+func (f *FS) check(name, val string) error {
+	dir, base := path.Split(name)
+	atoi := func(name string) int {
+		n, _ := strconv.Atoi(f.file(name))
+		return n
+	}
+	switch base {
+	case "scaling_min_freq", "scaling_max_freq", "scaling_setspeed",
+		"min_perf_pct", "max_perf_pct", "no_turbo", "boost", "online", "disable":
+		if _, err := strconv.Atoi(val); err != nil {
+			return syscall.EINVAL
+		}
+	}
+	n, _ := strconv.Atoi(val)
+	switch base {
+	case "scaling_min_freq":
+		if n > atoi(dir+"scaling_max_freq") {
+			return syscall.EINVAL
+		}
+	case "scaling_max_freq":
+		if n < atoi(dir+"scaling_min_freq") {
+			return syscall.EINVAL
+		}
+	case "min_perf_pct":
+		if n > atoi(dir+"max_perf_pct") {
+			return syscall.EINVAL
+		}
+	case "max_perf_pct":
+		if n < atoi(dir+"min_perf_pct") {
+			return syscall.EINVAL
+		}
+	case "scaling_governor":
+		if !contains(strings.Fields(f.file(dir+"scaling_available_governors")), val) {
+			return syscall.EINVAL
+		}
+	case "energy_performance_preference":
+		if !contains(strings.Fields(f.file(dir+"energy_performance_available_preferences")), val) {
+			return syscall.EINVAL
+		}
+	case "scaling_setspeed":
+		if f.file(dir+"scaling_governor") != "userspace" {
+			return syscall.EINVAL
+		}
+	case "control":
+		if !contains([]string{"on", "off", "forceoff"}, val) {
+			return syscall.EINVAL
+		}
+	}
+	return nil
+}
+
+// update keeps scaling_cur_freq consistent after a write to name.
+func (f *FS) update(name string) {
+	dir, base := path.Split(name)
+	switch base {
+	case "scaling_min_freq", "scaling_max_freq", "scaling_setspeed", "scaling_governor":
+	default:
+		return
+	}
+	min, _ := strconv.Atoi(f.file(dir + "scaling_min_freq"))
+	max, _ := strconv.Atoi(f.file(dir + "scaling_max_freq"))
+	cur := max
+	switch f.file(dir + "scaling_governor") {
+	case "powersave":
+		cur = min
+	case "userspace":
+		if speed, err := strconv.Atoi(f.file(dir + "scaling_setspeed")); err == nil {
+			cur = speed
+		}
+	}
+	if cur < min {
+		cur = min
+	}
+	if cur > max {
+		cur = max
+	}
+	f.files[dir+"scaling_cur_freq"].Data = []byte(strconv.Itoa(cur) + "\n")
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package cpupower
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// An IdleState is an idle state (C-state) of a CPU.
+type IdleState struct {
+	sys     *System
+	path    string
+	name    string
+	latency int
+}
+
+// IdleStates returns the idle states of cpu, from the shallowest to
+// the deepest. It returns nil if cpu has no idle states or cpuidle is
+// disabled.
+func (s *System) IdleStates(cpu int) ([]*IdleState, error) {
+	dir := path.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "cpuidle")
+	entries, err := fs.ReadDir(s.fs, dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var states []*IdleState
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), "state") {
+			continue
+		}
+		sdir := path.Join(dir, e.Name())
+		name, err := s.readString(path.Join(sdir, "name"))
+		if err != nil {
+			return nil, err
+		}
+		latency, err := s.readInt(path.Join(sdir, "latency"))
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, &IdleState{s, sdir, name, latency})
+	}
+	return states, nil
+}
+
+// Path returns the directory of this idle state relative to the root
+// of its System, which identifies it across calls to IdleStates.
+func (st *IdleState) Path() string {
+	return st.path
+}
+
+// Name returns the name of this idle state, such as "C6".
+func (st *IdleState) Name() string {
+	return st.name
+}
+
+// Latency returns the exit latency of this idle state in
+// microseconds.
+func (st *IdleState) Latency() int {
+	return st.latency
+}
+
+// Disabled reports whether this idle state is disabled.
+func (st *IdleState) Disabled() (bool, error) {
+	v, err := st.sys.readInt(path.Join(st.path, "disable"))
+	return v != 0, err
+}
+
+// SetDisabled disables or enables this idle state.
+func (st *IdleState) SetDisabled(disabled bool) error {
+	v := 0
+	if disabled {
+		v = 1
+	}
+	return st.sys.writeInt(path.Join(st.path, "disable"), v)
+}
+
+// SetIdleStateDisabled disables or enables the idle state at path,
+// as returned by IdleState.Path.
+func (s *System) SetIdleStateDisabled(path string, disabled bool) error {
+	return (&IdleState{sys: s, path: path}).SetDisabled(disabled)
+}
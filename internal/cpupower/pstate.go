@@ -0,0 +1,87 @@
+package cpupower
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Scaling drivers that need special handling.
+const (
+	// DriverIntelPState is the intel_pstate driver in active mode
+	// (it is intel_cpufreq in passive mode). It selects frequencies
+	// itself, within global performance limits set by min_perf_pct
+	// and max_perf_pct.
+	DriverIntelPState = "intel_pstate"
+	// DriverAMDPStateEPP is the amd_pstate driver in active mode. The
+	// hardware selects frequencies within the scaling limits, guided
+	// by the energy performance preference.
+	DriverAMDPStateEPP = "amd-pstate-epp"
+)
+
+const intelPStateDir = "devices/system/cpu/intel_pstate"
+
+// Driver returns the name of the scaling driver of this CPU, such as
+// "intel_pstate", "amd-pstate-epp" or "acpi-cpufreq".
+func (d *Domain) Driver() (string, error) {
+	return d.sys.readString(path.Join(d.path, "scaling_driver"))
+}
+
+// CurrentFreq returns the current frequency of this CPU in kHz, as
+// reported by the scaling driver.
+func (d *Domain) CurrentFreq() (int, error) {
+	return d.sys.readInt(path.Join(d.path, "scaling_cur_freq"))
+}
+
+// EnergyPerfPreference returns the energy performance preference
+// (EPP) hint of this CPU. Only drivers that let the hardware select
+// frequencies, such as intel_pstate and amd_pstate in active mode,
+// support it; otherwise it returns an error satisfying
+// os.IsNotExist.
+func (d *Domain) EnergyPerfPreference() (string, error) {
+	return d.sys.readString(path.Join(d.path, "energy_performance_preference"))
+}
+
+// AvailableEnergyPerfPreferences returns the EPP hints this CPU
+// supports.
+func (d *Domain) AvailableEnergyPerfPreferences() ([]string, error) {
+	data, err := fs.ReadFile(d.sys.fs, path.Join(d.path, "energy_performance_available_preferences"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// SetEnergyPerfPreference sets the EPP hint of this CPU.
+func (d *Domain) SetEnergyPerfPreference(pref string) error {
+	return d.sys.writeString(path.Join(d.path, "energy_performance_preference"), pref)
+}
+
+// PerfPct returns the global intel_pstate performance limits, in
+// percent of the maximum frequency.
+func (s *System) PerfPct() (int, int, error) {
+	min, err := s.readInt(path.Join(intelPStateDir, "min_perf_pct"))
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := s.readInt(path.Join(intelPStateDir, "max_perf_pct"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return min, max, nil
+}
+
+// SetPerfPct sets the global intel_pstate performance limits, in
+// percent of the maximum frequency.
+func (s *System) SetPerfPct(min, max int) error {
+	// As with SetRange, an empty range is rejected, so try both
+	// orders.
+	err1 := s.writeInt(path.Join(intelPStateDir, "min_perf_pct"), min)
+	if err2 := s.writeInt(path.Join(intelPStateDir, "max_perf_pct"), max); err2 != nil {
+		return err2
+	}
+	if err1 != nil {
+		err1 = s.writeInt(path.Join(intelPStateDir, "min_perf_pct"), min)
+	}
+	return err1
+}
@@ -0,0 +1,71 @@
+package cpupower
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+const powercapDir = "class/powercap"
+
+// An EnergyCounter is a RAPL (running average power limit) energy
+// counter of a CPU package.
+type EnergyCounter struct {
+	sys      *System
+	path     string
+	name     string
+	maxRange uint64
+}
+
+// EnergyCounters returns the energy counters of the CPU packages of
+// this system, such as "package-0". It returns none if the host does
+// not support RAPL. Reading the counters usually requires root.
+func (s *System) EnergyCounters() ([]*EnergyCounter, error) {
+	entries, err := fs.ReadDir(s.fs, powercapDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var counters []*EnergyCounter
+	for _, e := range entries {
+		// Zones such as intel-rapl:0 are packages; subzones such
+		// as intel-rapl:0:0 are parts of them.
+		if !strings.HasPrefix(e.Name(), "intel-rapl:") || strings.Count(e.Name(), ":") != 1 {
+			continue
+		}
+		dir := path.Join(powercapDir, e.Name())
+		name, err := s.readString(path.Join(dir, "name"))
+		if err != nil {
+			return nil, err
+		}
+		maxRange, err := s.readInt(path.Join(dir, "max_energy_range_uj"))
+		if err != nil {
+			return nil, err
+		}
+		counters = append(counters, &EnergyCounter{s, dir, name, uint64(maxRange)})
+	}
+	return counters, nil
+}
+
+// Name returns the name of the counter's zone, such as "package-0".
+func (c *EnergyCounter) Name() string {
+	return c.name
+}
+
+// Energy returns the value of the counter in microjoules. It wraps
+// around at an unspecified value; use Delta to compare readings.
+func (c *EnergyCounter) Energy() (uint64, error) {
+	v, err := c.sys.readInt(path.Join(c.path, "energy_uj"))
+	return uint64(v), err
+}
+
+// Delta returns the energy in microjoules consumed between two
+// readings of the counter, accounting for a wraparound in between.
+func (c *EnergyCounter) Delta(from, to uint64) uint64 {
+	if to < from {
+		return c.maxRange - from + to
+	}
+	return to - from
+}
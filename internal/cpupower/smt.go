@@ -0,0 +1,81 @@
+package cpupower
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const smtControlPath = "devices/system/cpu/smt/control"
+
+// CPUs returns the numbers of all CPUs of this system, online or
+// not, sorted.
+func (s *System) CPUs() ([]int, error) {
+	entries, err := fs.ReadDir(s.fs, cpuDir)
+	if err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for _, e := range entries {
+		if !e.IsDir() || !cpuRe.MatchString(e.Name()) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(e.Name(), "cpu"))
+		if err != nil {
+			continue
+		}
+		cpus = append(cpus, n)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// ThreadSiblings returns the CPUs sharing a core with cpu, including
+// cpu itself. It is only known for online CPUs.
+func (s *System) ThreadSiblings(cpu int) ([]int, error) {
+	list, err := s.readString(path.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "topology", "thread_siblings_list"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseCPUList(list)
+}
+
+// Online reports whether cpu is online. CPUs that cannot be taken
+// offline, such as cpu0 on most systems, are always online.
+func (s *System) Online(cpu int) (bool, error) {
+	v, err := s.readInt(path.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "online"))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return v != 0, err
+}
+
+// Hotpluggable reports whether cpu can be taken offline.
+func (s *System) Hotpluggable(cpu int) bool {
+	_, err := fs.Stat(s.fs, path.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "online"))
+	return err == nil
+}
+
+// SetOnline brings cpu online or takes it offline.
+func (s *System) SetOnline(cpu int, online bool) error {
+	v := 0
+	if online {
+		v = 1
+	}
+	return s.writeInt(path.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "online"), v)
+}
+
+// SMTControl returns the global SMT (hyperthreading) control setting,
+// such as "on", "off" or "notsupported".
+func (s *System) SMTControl() (string, error) {
+	return s.readString(smtControlPath)
+}
+
+// SetSMTControl sets the global SMT control setting to "on" or "off".
+func (s *System) SetSMTControl(control string) error {
+	return s.writeString(smtControlPath, control)
+}
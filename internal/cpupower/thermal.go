@@ -0,0 +1,62 @@
+package cpupower
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+const thermalDir = "class/thermal"
+
+// ThrottleCount returns the number of times cpu, or the package it
+// belongs to, has been throttled because it ran too hot, since boot.
+// It returns an error satisfying os.IsNotExist on hosts that do not
+// report thermal throttling.
+func (s *System) ThrottleCount(cpu int) (int, error) {
+	dir := path.Join(cpuDir, fmt.Sprintf("cpu%d", cpu), "thermal_throttle")
+	total, found := 0, false
+	for _, name := range []string{"core_throttle_count", "package_throttle_count"} {
+		n, err := s.readInt(path.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		total, found = total+n, true
+	}
+	if !found {
+		return 0, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+	}
+	return total, nil
+}
+
+// MaxTemperature returns the highest temperature of all thermal zones
+// in degrees Celsius. It returns an error satisfying os.IsNotExist on
+// hosts without thermal zones.
+func (s *System) MaxTemperature() (float64, error) {
+	entries, err := fs.ReadDir(s.fs, thermalDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	max, found := 0.0, false
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), "thermal_zone") {
+			continue
+		}
+		milli, err := s.readInt(path.Join(thermalDir, e.Name(), "temp"))
+		if err != nil || milli <= 0 {
+			// Some zones report bogus readings, or fail to
+			// read at all.
+			continue
+		}
+		if t := float64(milli) / 1000; !found || t > max {
+			max, found = t, true
+		}
+	}
+	if !found {
+		return 0, &os.PathError{Op: "open", Path: thermalDir, Err: os.ErrNotExist}
+	}
+	return max, nil
+}
@@ -0,0 +1,214 @@
+//go:build linux
+// +build linux
+
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.design/x/bench/internal/cpupower"
+)
+
+// A cgroup is a cgroup v2 cpuset partition holding the CPUs of an
+// exclusive lock holder. As a partition root, its CPUs are taken
+// away from all other cgroups, so other tasks are confined to the
+// remaining CPUs until it is removed.
+type cgroup struct {
+	root, dir string
+
+	// isolated reports whether the partition is valid. If it is
+	// not, for example because another cgroup claims some of the
+	// CPUs, the cgroup still restricts its own tasks to its CPUs.
+	isolated bool
+
+	// origins is the cgroup each process added to g came from, by
+	// pid, to move it back to on removal.
+	origins map[int]string
+}
+
+// createCgroup creates a cpuset partition for cpus under the cgroup
+// v2 hierarchy mounted at root.
+func createCgroup(root string, cpus []int) (*cgroup, error) {
+	controllers, err := ioutil.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil || !contains(strings.Fields(string(controllers)), "cpuset") {
+		return nil, fmt.Errorf("cgroup v2 with the cpuset controller is not mounted at %s", root)
+	}
+	if err := writeCgroupFile(filepath.Join(root, "cgroup.subtree_control"), "+cpuset"); err != nil {
+		return nil, err
+	}
+
+	g := &cgroup{root: root, dir: filepath.Join(root, "bench-"+cpupower.FormatCPUList(cpus))}
+	if err := saveCgroup(g.dir); err != nil {
+		return nil, fmt.Errorf("saving cgroup: %v", err)
+	}
+	err = os.Mkdir(g.dir, 0755)
+	if os.IsExist(err) {
+		// Left behind by a holder whose removal failed.
+		err = nil
+	}
+	if err == nil {
+		err = writeCgroupFile(filepath.Join(g.dir, "cpuset.cpus"), cpupower.FormatCPUList(cpus))
+	}
+	if err != nil {
+		if g.remove() == nil {
+			forgetState(&savedState{Cgroups: []string{g.dir}})
+		}
+		return nil, err
+	}
+
+	// The kernel accepts the write but marks the partition invalid
+	// if it cannot take the CPUs exclusively, so read it back.
+	partition := filepath.Join(g.dir, "cpuset.cpus.partition")
+	if writeCgroupFile(partition, "root") == nil {
+		data, err := ioutil.ReadFile(partition)
+		g.isolated = err == nil && strings.TrimSpace(string(data)) == "root"
+		if !g.isolated {
+			writeCgroupFile(partition, "member")
+		}
+	}
+	return g, nil
+}
+
+// add moves process pid and all of its threads into g.
+func (g *cgroup) add(pid int) error {
+	if _, ok := g.origins[pid]; !ok {
+		data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+		if err != nil {
+			return err
+		}
+		origin, err := parseProcCgroup(data)
+		if err != nil {
+			return fmt.Errorf("process %d: %v", pid, err)
+		}
+		if g.origins == nil {
+			g.origins = make(map[int]string)
+		}
+		g.origins[pid] = filepath.Join(g.root, origin)
+	}
+	return writeCgroupFile(filepath.Join(g.dir, "cgroup.procs"), strconv.Itoa(pid))
+}
+
+// remove moves any remaining processes of g back to the cgroups they
+// came from and removes g, giving its CPUs back to the other cgroups.
+func (g *cgroup) remove() error {
+	return removeCgroup(g.root, g.dir, g.origins)
+}
+
+// removeCgroup removes the cgroup dir. Its remaining processes are
+// moved back to their cgroup in origins, or to that of the closest
+// ancestor process in origins, such as for the test binaries started
+// by an isolated go test. Processes with no known origin are left in
+// place, and the cgroup is not removed, so that they do not escape
+// the limits of their original cgroup.
+func removeCgroup(root, dir string, origins map[int]string) error {
+	procs, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var stranded []string
+	for _, f := range strings.Fields(string(procs)) {
+		pid, _ := strconv.Atoi(f)
+		origin := processOrigin(pid, origins)
+		if origin == "" {
+			stranded = append(stranded, f)
+			continue
+		}
+		// The process may exit at any time, so ignore failures
+		// and let the removal report a cgroup that is still busy.
+		writeCgroupFile(filepath.Join(origin, "cgroup.procs"), f)
+	}
+	if stranded != nil {
+		return fmt.Errorf("%s: not removed, processes %s have no known cgroup to return to", dir, strings.Join(stranded, ", "))
+	}
+	err = os.Remove(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// processOrigin returns the cgroup in origins of pid or of its closest
+// ancestor, or "" if there is none.
+func processOrigin(pid int, origins map[int]string) string {
+	for pid > 1 {
+		if origin, ok := origins[pid]; ok {
+			return origin
+		}
+		data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return ""
+		}
+		if pid, err = parseParentPid(data); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// parseProcCgroup returns the cgroup v2 path of a process from its
+// /proc/<pid>/cgroup, such as "/user.slice/session-1.scope".
+func parseProcCgroup(data []byte) (string, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", fmt.Errorf("not in a cgroup v2 hierarchy")
+}
+
+// parseParentPid returns the parent process ID from the content of
+// /proc/<pid>/stat. The command name before it is in parentheses and
+// may contain spaces and parentheses itself.
+func parseParentPid(data []byte) (int, error) {
+	s := string(data)
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("malformed stat")
+	}
+	// pid (comm) state ppid ...
+	f := strings.Fields(s[i+1:])
+	if len(f) < 2 {
+		return 0, fmt.Errorf("malformed stat")
+	}
+	return strconv.Atoi(f[1])
+}
+
+// writeCgroupFile writes to an existing cgroup interface file.
+func writeCgroupFile(name, data string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte(data))
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// ownedBy reports whether process pid belongs to the user with the
+// given uid.
+func ownedBy(pid int, uid uint32) bool {
+	var st syscall.Stat_t
+	if err := syscall.Stat(fmt.Sprintf("/proc/%d", pid), &st); err != nil {
+		return false
+	}
+	return st.Uid == uid
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package lock
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseProcCgroup(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+		ok   bool
+	}{
+		{"0::/user.slice/user-1000.slice/session-2.scope\n", "/user.slice/user-1000.slice/session-2.scope", true},
+		{"0::/\n", "/", true},
+		// Hybrid hierarchies list the v1 controllers first.
+		{"12:cpuset:/\n1:name=systemd:/system.slice\n0::/system.slice/bench.service\n", "/system.slice/bench.service", true},
+		{"12:cpuset:/\n1:name=systemd:/\n", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseProcCgroup([]byte(tt.data))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseProcCgroup(%q) = %q, %v, want %q, ok %v", tt.data, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseParentPid(t *testing.T) {
+	tests := []struct {
+		data string
+		want int
+		ok   bool
+	}{
+		{"1234 (go) S 1000 1234 1000 34816 ...", 1000, true},
+		{"1235 (a b) (c)) R 1234 1235 1000 0", 1234, true},
+		{"1236 (go", 0, false},
+		{"1237 (go) S", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseParentPid([]byte(tt.data))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseParentPid(%q) = %d, %v, want %d, ok %v", tt.data, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProcessOrigin(t *testing.T) {
+	// This process and its parent exist; the origin of the parent is
+	// also that of its children.
+	origins := map[int]string{os.Getppid(): "/sys/fs/cgroup/user.slice"}
+	if got := processOrigin(os.Getpid(), origins); got != "/sys/fs/cgroup/user.slice" {
+		t.Errorf("origin of child = %q, want that of its parent", got)
+	}
+	if got := processOrigin(os.Getpid(), nil); got != "" {
+		t.Errorf("origin without known processes = %q, want none", got)
+	}
+}
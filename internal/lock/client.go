@@ -1,7 +1,7 @@
 package lock
 
 import (
-	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -11,8 +11,8 @@ import (
 type Client struct {
 	c net.Conn
 
-	gr *gob.Encoder
-	gw *gob.Decoder
+	enc *json.Encoder
+	dec *json.Decoder
 }
 
 // NewClient returns a lock client
@@ -23,49 +23,92 @@ func NewClient() *Client {
 		return nil
 	}
 
-	// Send credentials.
-	err = writeCredentials(c.(*net.UnixConn))
-	if err != nil {
-		log.Fatal("failed to send credentials: ", err)
+	cl := &Client{c, json.NewEncoder(c), json.NewDecoder(c)}
+	var resp response
+	if err := cl.do(&request{Type: typeHello, Version: ProtocolVersion}, &resp); err != nil {
+		c.Close()
+		log.Printf("failed to connect bench daemon: %v", err)
+		return nil
 	}
-
-	gr, gw := gob.NewEncoder(c), gob.NewDecoder(c)
-
-	return &Client{c, gr, gw}
+	return cl
 }
 
-func (c *Client) do(action perflockAction, response interface{}) {
-	err := c.gr.Encode(action)
-	if err != nil {
-		log.Fatal(err)
+func (c *Client) do(req *request, resp *response) error {
+	if err := c.enc.Encode(req); err != nil {
+		return err
 	}
-
-	err = c.gw.Decode(response)
-	if err != nil {
-		log.Fatal(err)
+	if err := c.dec.Decode(resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.Type != req.Type {
+		return fmt.Errorf("unexpected %q response to %q request", resp.Type, req.Type)
 	}
+	return nil
 }
 
-// Acquire acuiqres the lock
-func (c *Client) Acquire(shared, nonblocking bool, msg string) bool {
-	var ok bool
-	c.do(perflockAction{actionAcquire{Shared: shared, NonBlocking: nonblocking, Msg: msg}}, &ok)
-	return ok
+// Acquire acuiqres the lock. If cpus is not empty, only that list of
+// CPUs is reserved, such as "4-7".
+func (c *Client) Acquire(shared, nonblocking bool, cpus, msg string) (bool, error) {
+	var resp response
+	err := c.do(&request{Type: typeAcquire, Shared: shared, NonBlocking: nonblocking, CPUs: cpus, Msg: msg}, &resp)
+	return resp.OK, err
 }
 
 // List lists all perflock actions
-func (c *Client) List() []string {
-	var list []string
-	c.do(perflockAction{actionList{}}, &list)
-	return list
+func (c *Client) List() ([]string, error) {
+	var resp response
+	err := c.do(&request{Type: typeList}, &resp)
+	return resp.List, err
 }
 
-// SetCPUFreq sets the given cpu frequency
-func (c *Client) SetCPUFreq(percent int) error {
-	var err string
-	c.do(perflockAction{actionSetCPUFreq{Percent: percent}}, &err)
-	if err == "" {
-		return nil
+// CPUFreq reports the CPU frequency set by the daemon.
+type CPUFreq struct {
+	// Percent is the percent between the lowest and highest
+	// available frequencies that was applied.
+	Percent int
+	// Target, Limit and Freq are the mean target frequency, upper
+	// frequency limit actually applied and frequency achieved in
+	// kHz, or 0 if the frequency was not changed.
+	Target, Limit, Freq int
+}
+
+// SetCPUFreq sets the given cpu frequency, or the daemon's default
+// if percent is CPUFreqDefault. If governor or epp are not empty, it
+// also switches to that scaling governor and energy performance
+// preference.
+func (c *Client) SetCPUFreq(percent int, governor, epp string) (*CPUFreq, error) {
+	req := &request{Type: typeSetCPUFreq, Governor: governor, EPP: epp}
+	if percent != CPUFreqDefault {
+		req.Percent = &percent
 	}
-	return fmt.Errorf("%s", err)
+	var resp response
+	if err := c.do(req, &resp); err != nil {
+		return nil, err
+	}
+	return &CPUFreq{resp.Percent, resp.Target, resp.Limit, resp.Freq}, nil
+}
+
+// Isolate moves the process pid into a cpuset partition of the CPUs
+// reserved by Acquire. It reports whether the daemon isolated them;
+// it does not when isolation is disabled in its configuration.
+func (c *Client) Isolate(pid int) (bool, error) {
+	var resp response
+	err := c.do(&request{Type: typeIsolate, Pid: pid}, &resp)
+	return resp.Isolated, err
+}
+
+// DisableTurbo disables frequency boosting (turbo) until the lock is
+// released. The lock must be held exclusively.
+func (c *Client) DisableTurbo() error {
+	var resp response
+	return c.do(&request{Type: typeNoTurbo}, &resp)
+}
+
+// Close closes the connection to the daemon, which releases the lock
+// if it is held.
+func (c *Client) Close() error {
+	return c.c.Close()
 }
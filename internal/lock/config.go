@@ -0,0 +1,146 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Configpath is the default configuration file of the lock daemon.
+var Configpath = "/etc/bench/daemon.json"
+
+// Config is the configuration of the lock daemon. It is read from a
+// JSON file, for example:
+//
+//	{
+//		"socket": "/var/run/bench-node1.socket",
+//		"socket_group": "bench",
+//		"socket_mode": "0660",
+//		"allow_groups": ["bench"],
+//		"cpufreq": 80,
+//		"max_lease": "2h",
+//		"isolate": true,
+//		"smt_off": true,
+//		"max_idle_latency": 10,
+//		"log": "/var/log/bench.log"
+//	}
+type Config struct {
+	// Socket is the path of the unix socket the daemon listens on.
+	Socket string `json:"socket"`
+	// SocketGroup is the group that owns the socket. If empty, the
+	// group of the daemon is used.
+	SocketGroup string `json:"socket_group"`
+	// SocketMode is the octal permission mode of the socket.
+	SocketMode string `json:"socket_mode"`
+
+	// AllowUsers and AllowGroups list the users and groups that
+	// may use the daemon. If both are empty, all users may use it.
+	// Root is always allowed.
+	AllowUsers  []string `json:"allow_users"`
+	AllowGroups []string `json:"allow_groups"`
+
+	// CPUFreq is the CPU frequency percent used when a client does
+	// not request one.
+	CPUFreq int `json:"cpufreq"`
+
+	// MaxLease is the longest duration a client may hold the lock
+	// before it is revoked, such as "2h". Zero means no limit.
+	MaxLease string `json:"max_lease"`
+	// MaxQueue is the maximum number of current and pending lock
+	// acquisitions. Zero means no limit.
+	MaxQueue int `json:"max_queue"`
+
+	// Isolate enables moving the benchmarks of exclusive lock
+	// holders with a CPU set into a cgroup v2 cpuset partition, so
+	// that no other task runs on their CPUs.
+	Isolate bool `json:"isolate"`
+	// Cgroup is the mount point of the cgroup v2 hierarchy.
+	Cgroup string `json:"cgroup"`
+
+	// SMTOff takes the SMT (hyperthread) siblings of the reserved
+	// CPUs offline while the lock is held exclusively. CPU sets are
+	// extended to whole cores.
+	SMTOff bool `json:"smt_off"`
+	// MaxIdleLatency, if set, disables the idle states of the
+	// reserved CPUs whose exit latency exceeds this many
+	// microseconds while the lock is held exclusively.
+	MaxIdleLatency *int `json:"max_idle_latency"`
+
+	// State is the file used to journal CPU frequency settings.
+	State string `json:"state"`
+	// Sysfs is the root of the sysfs tree whose CPU frequency
+	// settings the daemon controls.
+	Sysfs string `json:"sysfs"`
+	// Log is the file the daemon logs to. If empty, the daemon
+	// logs to standard error.
+	Log string `json:"log"`
+
+	socketMode os.FileMode
+	maxLease   time.Duration
+}
+
+// DefaultConfig returns the default configuration of the lock daemon.
+// It does not depend on Socketpath, which clients may have changed.
+func DefaultConfig() *Config {
+	cfg := &Config{
+		Socket:     DefaultSocket,
+		SocketMode: "0777",
+		CPUFreq:    90,
+		State:      DefaultState,
+		Cgroup:     "/sys/fs/cgroup",
+		Sysfs:      "/sys",
+	}
+	if err := cfg.check(); err != nil {
+		panic("lock: invalid default config: " + err.Error())
+	}
+	return cfg
+}
+
+// LoadConfig reads the configuration of the lock daemon from the
+// named file. Settings missing from the file take their default
+// values.
+func LoadConfig(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if err := cfg.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// check validates cfg and computes its derived settings.
+func (cfg *Config) check() error {
+	mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	if err != nil || mode&^0777 != 0 {
+		return fmt.Errorf("invalid socket_mode %q", cfg.SocketMode)
+	}
+	cfg.socketMode = os.FileMode(mode)
+
+	if cfg.CPUFreq < 0 || cfg.CPUFreq > 100 {
+		return fmt.Errorf("cpufreq must be between 0 and 100")
+	}
+
+	cfg.maxLease = 0
+	if cfg.MaxLease != "" {
+		cfg.maxLease, err = time.ParseDuration(cfg.MaxLease)
+		if err != nil || cfg.maxLease < 0 {
+			return fmt.Errorf("invalid max_lease %q", cfg.MaxLease)
+		}
+	}
+	if cfg.MaxQueue < 0 {
+		return fmt.Errorf("max_queue must not be negative")
+	}
+	if cfg.MaxIdleLatency != nil && *cfg.MaxIdleLatency < 0 {
+		return fmt.Errorf("max_idle_latency must not be negative")
+	}
+	return nil
+}
@@ -4,61 +4,27 @@
 package lock
 
 import (
-	"fmt"
 	"net"
-	"os"
 	"syscall"
 )
 
-// TODO: Use SO_PEERCRED instead?
-
-func writeCredentials(c *net.UnixConn) error {
-	ucred := syscall.Ucred{Pid: int32(os.Getpid()), Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
-	credOob := syscall.UnixCredentials(&ucred)
-	credMsg := []byte("x")
-	n, oobn, err := c.WriteMsgUnix(credMsg, credOob, nil)
-	if err != nil {
-		return err
-	}
-	if n != 1 {
-		return fmt.Errorf("short send (%d bytes)", n)
-	}
-	if oobn != len(credOob) {
-		return fmt.Errorf("short OOB send (%d bytes)", oobn)
-	}
-	return nil
-}
-
+// readCredentials returns the credentials of the peer of c.
+//
+// The credentials are taken from SO_PEERCRED so that clients don't
+// have to send them explicitly, which makes the daemon usable from
+// any language or tool that can open a unix socket.
 func readCredentials(c *net.UnixConn) (*syscall.Ucred, error) {
-	// Enable receiving credentials on c.
-	f, err := c.File()
+	rc, err := c.SyscallConn()
 	if err != nil {
 		return nil, err
 	}
-	err = syscall.SetsockoptInt(int(f.Fd()), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
-	f.Close()
+	var ucred *syscall.Ucred
+	var uerr error
+	err = rc.Control(func(fd uintptr) {
+		ucred, uerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	// Receive credentials.
-	buf := make([]byte, 1)
-	oob := make([]byte, 128)
-	n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
-	if err != nil {
-		return nil, err
-	}
-	if n != 1 {
-		return nil, fmt.Errorf("expected 1 byte, got %d", n)
-	}
-
-	// Parse OOB data.
-	scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
-	if err != nil {
-		return nil, err
-	}
-	if len(scms) != 1 {
-		return nil, fmt.Errorf("expected 1 control message, got %d", len(scms))
-	}
-	return syscall.ParseUnixCredentials(&scms[0])
+	return ucred, uerr
 }
@@ -7,7 +7,7 @@ import (
 	"log"
 )
 
-// RunDaemon runs lock daemon
-func RunDaemon() {
+// RunDaemon runs lock daemon with the given configuration
+func RunDaemon(cfg *Config) {
 	log.Fatal("running daemon on darwin systems are not supported.")
 }
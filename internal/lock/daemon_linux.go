@@ -4,48 +4,105 @@
 package lock
 
 import (
-	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.design/x/bench/internal/cpupower"
 )
 
-var theLock perflock
+var (
+	theLock perflock
+	config  = DefaultConfig()
 
-// RunDaemon runs lock daemon
-func RunDaemon() {
-	// check if daemon is running
-	c, _ := net.Dial("unix", Socketpath)
-	if c != nil {
-		c.Close()
-		log.Fatalf("The bench daemon is already running at %s !", Socketpath)
-		return
+	// cpus is the system whose CPU frequency settings the daemon
+	// controls.
+	cpus = cpupower.Host()
+)
+
+// RunDaemon runs lock daemon with the given configuration
+func RunDaemon(cfg *Config) {
+	config = cfg
+	Socketpath, Statepath = cfg.Socket, cfg.State
+	cpus = cpupower.New(cpupower.DirFS(cfg.Sysfs))
+	if cfg.Log != "" {
+		f, err := os.OpenFile(cfg.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		log.SetFlags(log.LstdFlags)
 	}
 
-	os.Remove(Socketpath)
-	l, err := net.Listen("unix", Socketpath)
+	// Use the socket passed by systemd socket activation, if any.
+	l, err := activationListener()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer l.Close()
 
-	// Make the socket world-writable/connectable.
-	err = os.Chmod(Socketpath, 0777)
-	if err != nil {
-		log.Fatal(err)
+	// check if daemon is running
+	if l == nil {
+		c, _ := net.Dial("unix", Socketpath)
+		if c != nil {
+			c.Close()
+			log.Fatalf("The bench daemon is already running at %s !", Socketpath)
+			return
+		}
+	}
+
+	// Restore CPU frequency settings left behind by a previous
+	// daemon that died while they were changed.
+	if restored, err := restoreSavedState(); err != nil {
+		log.Printf("failed to restore CPU settings from %s: %v", Statepath, err)
+	} else if restored {
+		log.Printf("restored CPU settings from %s", Statepath)
+	}
+
+	if l == nil {
+		l = listen(cfg)
+	} else {
+		log.Printf("listening on %s passed by systemd", l.Addr())
+		checkActivationListener(l, cfg)
 	}
+	defer l.Close()
+
+	// Shut down on SIGTERM and SIGINT, restoring any CPU frequency
+	// settings changed by current lock holders.
+	var shutdown int32
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigCh
+		log.Printf("received %v, shutting down", sig)
+		sdNotify("STOPPING=1")
+		atomic.StoreInt32(&shutdown, 1)
+		l.Close()
+	}()
+
+	sdNotify("READY=1\n" + queueStatus())
 
 	// Receive connections.
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			log.Fatal(err)
+			if atomic.LoadInt32(&shutdown) != 0 {
+				break
+			}
+			log.Print(err)
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
 
 		go func(c net.Conn) {
@@ -53,17 +110,62 @@ func RunDaemon() {
 			NewServer(c).Serve()
 		}(conn)
 	}
+
+	if _, err := restoreSavedState(); err != nil {
+		log.Printf("failed to restore CPU settings from %s: %v", Statepath, err)
+	}
+}
+
+// listen creates the daemon's socket as configured by cfg.
+func listen(cfg *Config) net.Listener {
+	os.Remove(Socketpath)
+	l, err := net.Listen("unix", Socketpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Make the socket connectable by the configured group, or
+	// world-writable/connectable by default.
+	if cfg.SocketGroup != "" {
+		g, err := user.LookupGroup(cfg.SocketGroup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		if err := os.Chown(Socketpath, -1, gid); err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = os.Chmod(Socketpath, cfg.socketMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return l
+}
+
+// queueStatus returns the STATUS message reported to systemd.
+func queueStatus() string {
+	return fmt.Sprintf("STATUS=%d current and pending lock acquisitions", len(theLock.Queue()))
 }
 
 // Server is the bench lock server
 type Server struct {
 	c        net.Conn
+	uid      uint32
 	userName string
 
 	locker    *locker
 	acquiring bool
+	requested []int // CPUs asked for, before reservedCPUs
 
 	oldCPUFreqs []*cpuFreqSettings
+	oldPerfPct  *[2]int
+	oldBoost    *bool
+	cgroup      *cgroup
+
+	offline       []int
+	oldSMTControl string
+	disabledIdle  []string
 }
 
 // NewServer returns a bench lock server
@@ -83,180 +185,606 @@ func (s *Server) Serve() {
 		return
 	}
 
+	s.uid = ucred.Uid
 	u, err := user.LookupId(fmt.Sprintf("%d", ucred.Uid))
 	s.userName = "???"
 	if err == nil {
 		s.userName = u.Username
+	} else {
+		u = nil
 	}
+	denied := !config.allowed(ucred.Uid, u)
 
-	// Receive incoming actions. We do this in a goroutine so the
-	// main handler can select on EOF or lock acquisition.
-	actions := make(chan perflockAction)
+	// Receive incoming requests. We do this in a goroutine so the
+	// main handler can select on EOF or lock acquisition. done is
+	// closed when the handler returns, for example when the lock
+	// is revoked, so the goroutine doesn't outlive the connection.
+	requests := make(chan *request)
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
-		gr := gob.NewDecoder(s.c)
+		defer close(requests)
+		dec := json.NewDecoder(s.c)
 		for {
-			var msg perflockAction
-			err := gr.Decode(&msg)
+			req := new(request)
+			err := dec.Decode(req)
 			if err != nil {
-				if err != io.EOF {
-					log.Print(err)
+				if err == io.EOF {
+					return
 				}
-				close(actions)
+				select {
+				case <-done:
+					return
+				default:
+				}
+				log.Print(err)
+				// The stream cannot be resynchronized after a
+				// malformed request, so report it before the
+				// connection is closed.
+				req = &request{Type: typeError}
+			}
+			select {
+			case requests <- req:
+			case <-done:
+				return
+			}
+			if err != nil {
 				return
 			}
-			actions <- msg
 		}
 	}()
 
-	// Process incoming actions.
+	// Process incoming requests.
 	var acquireC <-chan bool
-	gw := gob.NewEncoder(s.c)
+	var leaseC <-chan time.Time
+	enc := json.NewEncoder(s.c)
+	helloed := false
 	for {
+		var resp *response
 		select {
-		case action, ok := <-actions:
+		case req, ok := <-requests:
 			if !ok {
 				// Connection closed.
 				return
 			}
-			if s.acquiring {
-				log.Printf("protocol error: message while acquiring")
-				return
-			}
-			switch action := action.Action.(type) {
-			case actionAcquire:
-				if s.locker != nil {
-					log.Printf("protocol error: acquiring lock twice")
-					return
-				}
-				msg := fmt.Sprintf("%s\t%s\t%s", s.userName, time.Now().Format(time.Stamp), action.Msg)
-				if action.Shared {
-					msg += " [shared]"
+			switch {
+			case req.Type == typeError:
+				resp = errorf(ErrProtocol, "malformed request")
+			case denied:
+				resp = errorf(ErrDenied, "user %s is not allowed to use the bench daemon", s.userName)
+			case !helloed && req.Type != typeHello:
+				resp = errorf(ErrProtocol, "expected hello, got %q", req.Type)
+			case s.acquiring:
+				resp = errorf(ErrProtocol, "%q request while acquiring", req.Type)
+			default:
+				resp = s.handle(req)
+				if req.Type == typeHello && resp.Error == nil {
+					helloed = true
 				}
-				s.locker = theLock.Enqueue(action.Shared, action.NonBlocking, msg)
-				if s.locker != nil {
+				if req.Type == typeAcquire && s.acquiring {
 					// Enqueued. Wait for acquire.
-					s.acquiring = true
 					acquireC = s.locker.C
-				} else {
-					// Non-blocking acquire failed.
-					if err := gw.Encode(false); err != nil {
-						log.Print(err)
-						return
-					}
 				}
+			}
 
-			case actionList:
-				list := theLock.Queue()
-				if err := gw.Encode(list); err != nil {
-					log.Print(err)
-					return
+		case <-acquireC:
+			// Lock acquired.
+			s.acquiring, acquireC = false, nil
+			if !s.locker.shared {
+				if err := s.quietCPUs(); err != nil {
+					log.Printf("%s: %v", s.userName, err)
 				}
+			}
+			resp = &response{Type: typeAcquire, OK: true}
+			if config.maxLease > 0 {
+				leaseC = time.After(config.maxLease)
+			}
 
-			case actionSetCPUFreq:
-				if s.locker == nil {
-					log.Printf("protocol error: setting cpuFreq without lock")
-					return
-				}
-				err := s.setCPUFreq(action.Percent)
-				errString := ""
-				if err != nil {
-					errString = err.Error()
-				}
-				if err := gw.Encode(errString); err != nil {
-					log.Print(err)
-					return
-				}
+		case <-leaseC:
+			// Revoke the lock by closing the connection.
+			log.Printf("%s: revoking lock held for more than %v", s.userName, config.maxLease)
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		if resp.Error != nil {
+			log.Printf("%s: %s: %s", s.userName, resp.Error.Code, resp.Error.Message)
+		}
+		if err := enc.Encode(resp); err != nil {
+			log.Print(err)
+			return
+		}
+		if denied {
+			return
+		}
+	}
+}
 
-			default:
-				log.Printf("unknown message")
-				return
+// allowed reports whether the user with the given uid may use the
+// daemon. u is nil if the user could not be looked up.
+func (cfg *Config) allowed(uid uint32, u *user.User) bool {
+	if uid == 0 || len(cfg.AllowUsers) == 0 && len(cfg.AllowGroups) == 0 {
+		return true
+	}
+	if u == nil {
+		return false
+	}
+	for _, name := range cfg.AllowUsers {
+		if name == u.Username {
+			return true
+		}
+	}
+	gids, err := u.GroupIds()
+	if err != nil {
+		log.Printf("%s: %v", u.Username, err)
+		return false
+	}
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		for _, name := range cfg.AllowGroups {
+			if name == g.Name {
+				return true
 			}
+		}
+	}
+	return false
+}
 
-		case <-acquireC:
-			// Lock acquired.
-			s.acquiring, acquireC = false, nil
-			if err := gw.Encode(true); err != nil {
-				log.Print(err)
-				return
+// handle handles a single request and returns its response, or nil
+// if the response is deferred.
+func (s *Server) handle(req *request) *response {
+	switch req.Type {
+	case typeHello:
+		if req.Version != ProtocolVersion {
+			return errorf(ErrVersion, "unsupported protocol version %d, server speaks version %d", req.Version, ProtocolVersion)
+		}
+		return &response{Type: typeHello, Version: ProtocolVersion}
+
+	case typeAcquire:
+		if s.locker != nil {
+			return errorf(ErrProtocol, "acquiring lock twice")
+		}
+		if config.MaxQueue > 0 && len(theLock.Queue()) >= config.MaxQueue {
+			return errorf(ErrBusy, "lock queue is full (%d acquisitions)", config.MaxQueue)
+		}
+		var reserved []int
+		if req.CPUs != "" {
+			list, err := cpupower.ParseCPUList(req.CPUs)
+			if err != nil || len(list) == 0 {
+				return errorf(ErrProtocol, "invalid CPU list %q", req.CPUs)
 			}
+			if err := checkPresent(list); err != nil {
+				return errorf(ErrFailed, "%v", err)
+			}
+			s.requested, reserved = list, reservedCPUs(list)
+		}
+		msg := fmt.Sprintf("%s\t%s\t%s", s.userName, time.Now().Format(time.Stamp), req.Msg)
+		if req.Shared {
+			msg += " [shared]"
+		}
+		if reserved != nil {
+			msg += " [cpus " + cpupower.FormatCPUList(reserved) + "]"
+		}
+		s.locker = theLock.Enqueue(req.Shared, req.NonBlocking, reserved, msg)
+		sdNotify(queueStatus())
+		if s.locker == nil {
+			// Non-blocking acquire failed.
+			return &response{Type: typeAcquire, OK: false}
+		}
+		s.acquiring = true
+		return nil
+
+	case typeList:
+		return &response{Type: typeList, List: theLock.Queue()}
+
+	case typeSetCPUFreq:
+		if s.locker == nil {
+			return errorf(ErrNotHeld, "setting cpufreq without lock")
+		}
+		if s.locker.shared {
+			// Shared holders would journal each other's settings
+			// and restore them in the wrong order.
+			return errorf(ErrDenied, "setting cpufreq requires an exclusive lock")
+		}
+		percent := config.CPUFreq
+		if req.Percent != nil {
+			percent = *req.Percent
+		}
+		target, limit, freq, err := s.setCPUFreq(percent, req.Governor, req.EPP)
+		if err != nil {
+			return errorf(ErrFailed, "%v", err)
+		}
+		return &response{Type: typeSetCPUFreq, Percent: percent, Target: target, Limit: limit, Freq: freq}
+
+	case typeNoTurbo:
+		if s.locker == nil {
+			return errorf(ErrNotHeld, "disabling boost without lock")
+		}
+		if s.locker.shared || s.locker.cpus != nil {
+			return errorf(ErrDenied, "disabling boost requires an exclusive lock of all CPUs")
+		}
+		if err := s.disableBoost(); err != nil {
+			return errorf(ErrFailed, "%v", err)
+		}
+		return &response{Type: typeNoTurbo}
+
+	case typeIsolate:
+		if s.locker == nil || s.locker.shared || s.locker.cpus == nil {
+			return errorf(ErrNotHeld, "isolating CPUs without exclusive lock of a CPU set")
 		}
+		if s.uid != 0 && !ownedBy(req.Pid, s.uid) {
+			return errorf(ErrDenied, "process %d does not belong to %s", req.Pid, s.userName)
+		}
+		if !config.Isolate {
+			return &response{Type: typeIsolate}
+		}
+		isolated, err := s.isolate(req.Pid)
+		if err != nil {
+			return errorf(ErrFailed, "%v", err)
+		}
+		return &response{Type: typeIsolate, Isolated: isolated}
 	}
+	return errorf(ErrUnknown, "unknown request type %q", req.Type)
 }
 
 func (s *Server) drop() {
-	// Restore the CPU cpuFreq before releasing the lock.
+	// Give the CPUs back to other tasks and restore their settings
+	// before releasing the lock.
+	restored := new(savedState)
+	var err error
+	if s.cgroup != nil {
+		err = s.cgroup.remove()
+		restored.Cgroups = []string{s.cgroup.dir}
+	}
+	if s.offline != nil || s.oldSMTControl != "" || s.disabledIdle != nil {
+		if err1 := s.restoreQuietCPUs(); err1 != nil && err == nil {
+			err = err1
+		}
+		restored.Offline, restored.SMTControl, restored.IdleDisabled = s.offline, s.oldSMTControl, s.disabledIdle
+	}
 	if s.oldCPUFreqs != nil {
-		s.restoreCPUFreq()
-		s.oldCPUFreqs = nil
+		if err1 := s.restoreCPUFreq(); err1 != nil && err == nil {
+			err = err1
+		}
+		for _, g := range s.oldCPUFreqs {
+			restored.CPUFreqs = append(restored.CPUFreqs, savedCPUFreq{Path: g.domain.Path()})
+		}
+		restored.PerfPct = s.oldPerfPct
+	}
+	if s.oldBoost != nil {
+		if err1 := cpus.SetBoost(*s.oldBoost); err1 != nil && err == nil {
+			err = err1
+		}
+		restored.Boost = s.oldBoost
 	}
+	if err != nil {
+		log.Printf("failed to restore CPU settings: %v", err)
+	} else if !restored.empty() {
+		if err := forgetState(restored); err != nil {
+			log.Print(err)
+		}
+	}
+	s.cgroup, s.offline, s.oldSMTControl, s.disabledIdle = nil, nil, "", nil
+	s.requested = nil
+	s.oldCPUFreqs, s.oldPerfPct, s.oldBoost = nil, nil, nil
+
 	// Release the lock.
 	if s.locker != nil {
 		theLock.Dequeue(s.locker)
 		s.locker = nil
+		sdNotify(queueStatus())
 	}
 }
 
+// isolate moves process pid into a cpuset partition of the reserved
+// CPUs, creating it on first use. It reports whether the partition
+// isolates the CPUs from other tasks.
+func (s *Server) isolate(pid int) (bool, error) {
+	if s.cgroup == nil {
+		g, err := createCgroup(config.Cgroup, s.locker.cpus)
+		if err != nil {
+			return false, err
+		}
+		if !g.isolated {
+			log.Printf("%s: %s is not a valid cpuset partition, CPUs are not isolated", s.userName, g.dir)
+		}
+		s.cgroup = g
+	}
+	return s.cgroup.isolated, s.cgroup.add(pid)
+}
+
+// checkPresent reports an error if any of list is not present on the
+// host.
+func checkPresent(list []int) error {
+	present, err := cpus.PresentCPUs()
+	if err != nil {
+		return err
+	}
+	have := make(map[int]bool, len(present))
+	for _, c := range present {
+		have[c] = true
+	}
+	for _, c := range list {
+		if !have[c] {
+			return fmt.Errorf("CPU %d does not exist", c)
+		}
+	}
+	return nil
+}
+
+// reservedCPUs extends cpus to whole frequency domains, since the
+// frequency of a CPU cannot be changed without changing the other
+// CPUs of its domain, and to whole cores if SMT siblings are taken
+// offline.
+func reservedCPUs(list []int) []int {
+	set := make(map[int]bool)
+	add := func(cpus []int) {
+		for _, c := range cpus {
+			set[c] = true
+		}
+	}
+	sorted := func() []int {
+		var cpus []int
+		for c := range set {
+			cpus = append(cpus, c)
+		}
+		sort.Ints(cpus)
+		return cpus
+	}
+
+	add(list)
+	if config.SMTOff {
+		for _, c := range list {
+			siblings, _ := cpus.ThreadSiblings(c)
+			add(siblings)
+		}
+	}
+	// Without frequency scaling, there are no domains to extend to.
+	domains, _ := cpus.Domains()
+	reserved := sorted()
+	for _, d := range domains {
+		if d.Covers(reserved) {
+			add(d.CPUs())
+		}
+	}
+	return sorted()
+}
+
 type cpuFreqSettings struct {
 	domain   *cpupower.Domain
 	min, max int
+	governor string
+	epp      string
 }
 
-func (s *Server) setCPUFreq(percent int) error {
-	domains, err := cpupower.Domains()
+// freqSettle is how long to wait for a frequency change to take
+// effect before reading back the achieved frequency.
+const freqSettle = 100 * time.Millisecond
+
+// setCPUFreq pins the CPU frequency to percent between the lowest
+// and highest available frequencies and, if governor or epp are not
+// empty, switches to that scaling governor and energy performance
+// preference. A negative percent leaves the frequency range
+// unchanged. If the lock holds a CPU set, only the domains covering
+// it are changed. It returns the mean target frequency, the mean upper
+// frequency limit actually applied and the mean frequency achieved,
+// in kHz.
+func (s *Server) setCPUFreq(percent int, governor, epp string) (target, limit, freq int, err error) {
+	domains, err := cpus.Domains()
 	if err != nil {
-		return err
+		return 0, 0, 0, err
+	}
+	if reserved := s.locker.cpus; reserved != nil {
+		var covering []*cpupower.Domain
+		for _, d := range domains {
+			if d.Covers(reserved) {
+				covering = append(covering, d)
+			}
+		}
+		if len(covering) == 0 {
+			return 0, 0, 0, fmt.Errorf("no power domains for CPUs %s", cpupower.FormatCPUList(reserved))
+		}
+		domains = covering
 	}
 	if len(domains) == 0 {
-		return fmt.Errorf("no power domains")
+		return 0, 0, 0, fmt.Errorf("no power domains")
 	}
 
-	// Save current frequency settings.
-	old := []*cpuFreqSettings{}
+	// Save current frequency settings, unless they were already
+	// saved by an earlier request. They are journaled to disk
+	// before anything is changed so that they survive a crash.
+	if s.oldCPUFreqs == nil {
+		old := []*cpuFreqSettings{}
+		for _, d := range domains {
+			min, max, err := d.CurrentRange()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			gov, err := d.Governor()
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			pref, err := d.EnergyPerfPreference()
+			if err != nil && !os.IsNotExist(err) {
+				return 0, 0, 0, err
+			}
+			old = append(old, &cpuFreqSettings{d, min, max, gov, pref})
+		}
+		if err := saveCPUFreqs(old); err != nil {
+			return 0, 0, 0, fmt.Errorf("saving cpufreq settings: %v", err)
+		}
+		s.oldCPUFreqs = old
+	}
+
+	// The scaling driver decides how the frequency is controlled.
+	driver, _ := domains[0].Driver()
+
+	// Set new settings.
 	for _, d := range domains {
-		min, max, err := d.CurrentRange()
+		if governor != "" {
+			if err := setGovernor(d, governor); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		if epp != "" {
+			if err := setEnergyPerfPreference(d, epp); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+		if percent < 0 {
+			continue
+		}
+		if driver == cpupower.DriverAMDPStateEPP && epp == "" {
+			// The hardware only keeps to the pinned range
+			// under load if it prefers performance.
+			if err := setEnergyPerfPreference(d, "performance"); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+
+		t := d.TargetFreq(percent)
+		target += t
+
+		err := d.SetRange(t, t)
 		if err != nil {
-			return err
+			return 0, 0, 0, err
 		}
-		old = append(old, &cpuFreqSettings{d, min, max})
+		if governor == "userspace" {
+			if err := d.SetSpeed(t); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+	}
+	if percent < 0 {
+		return 0, 0, 0, nil
 	}
-	s.oldCPUFreqs = old
+	target /= len(domains)
 
-	// Set new settings.
-	abs := func(x int) int {
-		if x < 0 {
-			return -x
+	// In active mode, intel_pstate clamps the limits of each domain
+	// to its global performance limits, so pin those as well. They
+	// apply to all CPUs, so leave them alone for a CPU set; the
+	// domain limits still take effect within the global ones.
+	if driver == cpupower.DriverIntelPState && s.locker.cpus == nil {
+		if err := s.setPerfPct(domains, percent); err != nil {
+			return 0, 0, 0, err
 		}
-		return x
 	}
+
+	// Report the limits that were actually applied rather than
+	// assuming the writes worked, since drivers clamp them, and the
+	// frequency the CPUs run at once the change took effect.
+	time.Sleep(freqSettle)
 	for _, d := range domains {
-		min, max, avail := d.AvailableRange()
-		target := (max-min)*percent/100 + min
-
-		// Find the nearest available frequency.
-		if len(avail) != 0 {
-			closest := avail[0]
-			for _, a := range avail {
-				if abs(target-a) < abs(target-closest) {
-					closest = a
-				}
-			}
-			target = closest
+		_, max, err := d.CurrentRange()
+		if err != nil {
+			return 0, 0, 0, err
 		}
+		limit += max
+		f, err := d.CurrentFreq()
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		freq += f
+	}
+	return target, limit / len(domains), freq / len(domains), nil
+}
 
-		err := d.SetRange(target, target)
+// setPerfPct sets the global intel_pstate performance limits to
+// percent between the lowest and highest available frequencies.
+func (s *Server) setPerfPct(domains []*cpupower.Domain, percent int) error {
+	if s.oldPerfPct == nil {
+		min, max, err := cpus.PerfPct()
 		if err != nil {
 			return err
 		}
+		if err := savePerfPct(min, max); err != nil {
+			return fmt.Errorf("saving intel_pstate settings: %v", err)
+		}
+		s.oldPerfPct = &[2]int{min, max}
 	}
 
-	return nil
+	// The limits are relative to the highest frequency.
+	_, max, _ := domains[0].AvailableRange()
+	if max == 0 {
+		return fmt.Errorf("%s: unknown maximum frequency", domains[0].Path())
+	}
+	pct := (domains[0].TargetFreq(percent)*100 + max - 1) / max
+	return cpus.SetPerfPct(pct, pct)
+}
+
+// setEnergyPerfPreference sets the EPP hint of d, if its scaling
+// driver supports it.
+func setEnergyPerfPreference(d *cpupower.Domain, pref string) error {
+	avail, err := d.AvailableEnergyPerfPreferences()
+	if os.IsNotExist(err) {
+		driver, _ := d.Driver()
+		return fmt.Errorf("scaling driver %q does not support energy performance preferences", driver)
+	} else if err != nil {
+		return err
+	}
+	for _, a := range avail {
+		if a == pref {
+			return d.SetEnergyPerfPreference(pref)
+		}
+	}
+	return fmt.Errorf("energy performance preference %q is not available, have: %s", pref, strings.Join(avail, " "))
+}
+
+// setGovernor switches d to the named governor, if d supports it.
+func setGovernor(d *cpupower.Domain, name string) error {
+	avail, err := d.AvailableGovernors()
+	if err != nil {
+		return err
+	}
+	for _, a := range avail {
+		if a == name {
+			return d.SetGovernor(name)
+		}
+	}
+	return fmt.Errorf("governor %q is not available, have: %s", name, strings.Join(avail, " "))
+}
+
+// disableBoost disables frequency boosting until the lock is
+// released.
+func (s *Server) disableBoost() error {
+	if s.oldBoost == nil {
+		enabled, err := cpus.Boost()
+		if err != nil {
+			return err
+		}
+		if err := saveBoost(enabled); err != nil {
+			return fmt.Errorf("saving boost setting: %v", err)
+		}
+		s.oldBoost = &enabled
+	}
+	return cpus.SetBoost(false)
 }
 
 func (s *Server) restoreCPUFreq() error {
 	var err error
 	for _, g := range s.oldCPUFreqs {
 		// Try to set all of the domains, even if one fails.
-		err1 := g.domain.SetRange(g.min, g.max)
+		err1 := g.domain.SetGovernor(g.governor)
+		if err1 != nil && err == nil {
+			err = err1
+		}
+		if g.epp != "" {
+			err1 = g.domain.SetEnergyPerfPreference(g.epp)
+			if err1 != nil && err == nil {
+				err = err1
+			}
+		}
+		err1 = g.domain.SetRange(g.min, g.max)
+		if err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	if s.oldPerfPct != nil {
+		err1 := cpus.SetPerfPct(s.oldPerfPct[0], s.oldPerfPct[1])
 		if err1 != nil && err == nil {
 			err = err1
 		}
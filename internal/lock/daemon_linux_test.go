@@ -0,0 +1,228 @@
+//go:build linux
+// +build linux
+
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.design/x/bench/internal/cpupower"
+	"golang.design/x/bench/internal/cpupower/cpupowertest"
+)
+
+const cpu0 = "devices/system/cpu/cpu0/cpufreq/"
+const cpu1 = "devices/system/cpu/cpu1/cpufreq/"
+
+// testSystem makes the daemon control the fake CPUs of fsys, with the
+// state file in a temporary directory.
+func testSystem(t *testing.T, fsys *cpupowertest.FS) {
+	oldCPUs, oldState, oldConfig := cpus, Statepath, config
+	cpus, Statepath, config = cpupower.New(fsys), filepath.Join(t.TempDir(), "state.json"), DefaultConfig()
+	t.Cleanup(func() { cpus, Statepath, config = oldCPUs, oldState, oldConfig })
+}
+
+// testServer returns a server holding the lock on the fake CPUs of
+// fsys.
+func testServer(t *testing.T, fsys *cpupowertest.FS, shared bool, reserved []int) *Server {
+	testSystem(t, fsys)
+	s := &Server{userName: "test"}
+	s.locker = theLock.Enqueue(shared, true, reserved, "test")
+	if s.locker == nil {
+		t.Fatal("lock is held")
+	}
+	t.Cleanup(s.drop)
+	return s
+}
+
+// checkFiles checks the content of the files of fsys.
+func checkFiles(t *testing.T, fsys *cpupowertest.FS, want map[string]string) {
+	t.Helper()
+	for name, w := range want {
+		if got := fsys.File(name); got != w {
+			t.Errorf("%s = %q, want %q", name, got, w)
+		}
+	}
+}
+
+func TestAcquireCPUs(t *testing.T) {
+	testSystem(t, cpupowertest.New(
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000},
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000},
+	))
+	tests := []struct {
+		cpus string
+		code string
+	}{
+		{"0-4000000000", ErrProtocol},
+		{"1-0", ErrProtocol},
+		{"x", ErrProtocol},
+		{"2", ErrFailed},
+		{"0-3", ErrFailed},
+	}
+	for _, tt := range tests {
+		s := &Server{userName: "test"}
+		resp := s.handle(&request{Type: typeAcquire, NonBlocking: true, CPUs: tt.cpus})
+		if resp == nil || resp.Error == nil || resp.Error.Code != tt.code {
+			t.Errorf("acquire of CPUs %q: got %+v, want %s error", tt.cpus, resp, tt.code)
+		}
+		if s.locker != nil {
+			s.drop()
+		}
+	}
+}
+
+func TestSetCPUFreq(t *testing.T) {
+	fsys := cpupowertest.New(
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000},
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000},
+	)
+	s := testServer(t, fsys, false, nil)
+
+	target, limit, freq, err := s.setCPUFreq(50, "performance", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != 1900000 || limit != 1900000 || freq != 1900000 {
+		t.Errorf("setCPUFreq(50) = %d, %d, %d, want 1900000, 1900000, 1900000", target, limit, freq)
+	}
+	checkFiles(t, fsys, map[string]string{
+		cpu0 + "scaling_min_freq": "1900000",
+		cpu0 + "scaling_max_freq": "1900000",
+		cpu0 + "scaling_governor": "performance",
+		cpu1 + "scaling_min_freq": "1900000",
+		cpu1 + "scaling_max_freq": "1900000",
+	})
+	if _, err := os.Stat(Statepath); err != nil {
+		t.Errorf("settings are not journaled: %v", err)
+	}
+
+	s.drop()
+	checkFiles(t, fsys, map[string]string{
+		cpu0 + "scaling_min_freq": "800000",
+		cpu0 + "scaling_max_freq": "3000000",
+		cpu0 + "scaling_governor": "schedutil",
+		cpu1 + "scaling_min_freq": "800000",
+		cpu1 + "scaling_max_freq": "3000000",
+	})
+	if _, err := os.Stat(Statepath); !os.IsNotExist(err) {
+		t.Errorf("state file is left behind after drop: %v", err)
+	}
+}
+
+func TestSetCPUFreqCPUSet(t *testing.T) {
+	fsys := cpupowertest.New(
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000},
+		cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000},
+	)
+	s := testServer(t, fsys, false, []int{1})
+
+	if _, _, _, err := s.setCPUFreq(0, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, fsys, map[string]string{
+		cpu0 + "scaling_max_freq": "3000000",
+		cpu1 + "scaling_max_freq": "800000",
+	})
+	s.drop()
+	checkFiles(t, fsys, map[string]string{cpu1 + "scaling_max_freq": "3000000"})
+}
+
+func TestSetCPUFreqIntelPState(t *testing.T) {
+	fsys := cpupowertest.New(cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Driver: cpupower.DriverIntelPState})
+	fsys.AddIntelPState("active", 10, 100, false)
+	s := testServer(t, fsys, false, nil)
+
+	if _, _, _, err := s.setCPUFreq(50, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	const pstate = "devices/system/cpu/intel_pstate/"
+	checkFiles(t, fsys, map[string]string{
+		pstate + "min_perf_pct": "64",
+		pstate + "max_perf_pct": "64",
+	})
+	s.drop()
+	checkFiles(t, fsys, map[string]string{
+		pstate + "min_perf_pct": "10",
+		pstate + "max_perf_pct": "100",
+	})
+}
+
+func TestSetCPUFreqAMDPState(t *testing.T) {
+	fsys := cpupowertest.New(cpupowertest.CPU{
+		MinFreq: 800000, MaxFreq: 3000000, Driver: cpupower.DriverAMDPStateEPP,
+		EPP: "balance_power", EPPs: []string{"default", "performance", "balance_power", "power"},
+	})
+	s := testServer(t, fsys, false, nil)
+
+	if _, _, _, err := s.setCPUFreq(50, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, fsys, map[string]string{cpu0 + "energy_performance_preference": "performance"})
+	s.drop()
+	checkFiles(t, fsys, map[string]string{cpu0 + "energy_performance_preference": "balance_power"})
+}
+
+func TestSetCPUFreqShared(t *testing.T) {
+	fsys := cpupowertest.New(cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000})
+	s := testServer(t, fsys, true, nil)
+
+	for _, typ := range []string{typeSetCPUFreq, typeNoTurbo} {
+		resp := s.handle(&request{Type: typ})
+		if resp.Error == nil || resp.Error.Code != ErrDenied {
+			t.Errorf("%s with shared lock: got %+v, want %s error", typ, resp, ErrDenied)
+		}
+	}
+	if w := fsys.Writes(); len(w) != 0 {
+		t.Errorf("shared holder wrote %v", w)
+	}
+}
+
+func TestOfflineSiblings(t *testing.T) {
+	tests := []struct {
+		requested []int
+		offline   []int
+	}{
+		// The requested CPUs stay online even if they are not
+		// the first of their core.
+		{[]int{3}, []int{1}},
+		{[]int{1}, []int{3}},
+		{[]int{1, 3}, nil},
+		// cpu0 cannot be taken offline.
+		{[]int{2}, nil},
+	}
+	for _, tt := range tests {
+		fsys := cpupowertest.New(
+			cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Siblings: "0,2"},
+			cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Siblings: "1,3"},
+			cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Siblings: "0,2"},
+			cpupowertest.CPU{MinFreq: 800000, MaxFreq: 3000000, Siblings: "1,3"},
+		)
+		testSystem(t, fsys)
+		config.SMTOff = true
+		s := &Server{userName: "test", requested: tt.requested}
+		s.locker = theLock.Enqueue(false, true, reservedCPUs(tt.requested), "test")
+		if s.locker == nil {
+			t.Fatal("lock is held")
+		}
+		if err := s.quietCPUs(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s.offline, tt.offline) {
+			t.Errorf("requesting CPUs %v took %v offline, want %v", tt.requested, s.offline, tt.offline)
+		}
+		for _, c := range tt.requested {
+			if online, _ := cpus.Online(c); !online {
+				t.Errorf("requesting CPUs %v took cpu%d offline", tt.requested, c)
+			}
+		}
+		s.drop()
+		for c := 1; c < 4; c++ {
+			if online, _ := cpus.Online(c); !online {
+				t.Errorf("cpu%d is offline after drop", c)
+			}
+		}
+	}
+}
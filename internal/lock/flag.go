@@ -6,8 +6,27 @@ import (
 	"strconv"
 )
 
+// Default paths of the lock daemon, unless configured otherwise.
+const (
+	DefaultSocket = "/var/run/bench.socket"
+	DefaultState  = "/var/lib/bench/cpufreq.json"
+)
+
 // Socketpath between lock daemon and client
-var Socketpath = "/var/run/bench.socket"
+var Socketpath = DefaultSocket
+
+// Statepath is the file that records the original CPU frequency
+// settings while they are changed by a lock holder, so that they can
+// be restored if the daemon dies before the holder releases the lock.
+var Statepath = DefaultState
+
+// Special CPU frequency percents.
+const (
+	// CPUFreqNone leaves the CPU frequency unchanged.
+	CPUFreqNone = -1
+	// CPUFreqDefault uses the default CPU frequency of the daemon.
+	CPUFreqDefault = -2
+)
 
 // CpufreqFlag ...
 type CpufreqFlag struct {
@@ -15,8 +34,11 @@ type CpufreqFlag struct {
 }
 
 func (f *CpufreqFlag) String() string {
-	if f.Percent < 0 {
+	switch f.Percent {
+	case CPUFreqNone:
 		return "none"
+	case CPUFreqDefault:
+		return "default"
 	}
 	return fmt.Sprintf("%d", f.Percent)
 }
@@ -24,11 +46,13 @@ func (f *CpufreqFlag) String() string {
 // Set set the cpu frequency percentage
 func (f *CpufreqFlag) Set(v string) error {
 	if v == "none" {
-		f.Percent = -1
+		f.Percent = CPUFreqNone
+	} else if v == "default" {
+		f.Percent = CPUFreqDefault
 	} else {
 		m := regexp.MustCompile(`^([0-9]+)$`).FindStringSubmatch(v)
 		if m == nil {
-			return fmt.Errorf("cpufreq must be \"none\" or \"N\"")
+			return fmt.Errorf("cpufreq must be \"none\", \"default\" or \"N\"")
 		}
 		f.Percent, _ = strconv.Atoi(m[1])
 	}
@@ -13,12 +13,16 @@ type locker struct {
 	shared bool
 	woken  bool
 
+	// cpus is the sorted set of CPUs reserved by this locker, or nil
+	// for all CPUs.
+	cpus []int
+
 	msg string
 }
 
-func (l *perflock) Enqueue(shared, nonblocking bool, msg string) *locker {
+func (l *perflock) Enqueue(shared, nonblocking bool, cpus []int, msg string) *locker {
 	ch := make(chan bool, 1)
-	locker := &locker{ch, ch, shared, false, msg}
+	locker := &locker{ch, ch, shared, false, cpus, msg}
 
 	// Enqueue.
 	l.l.Lock()
@@ -70,15 +74,40 @@ func (l *perflock) setQ(q []*locker) {
 			locker.c <- true
 		}
 	}
-	if q[0].shared {
-		// Wake all shared acquires at the head of the queue.
-		for _, locker := range q {
-			if !locker.shared {
-				break
+	// Wake every locker that is compatible with all lockers ahead of
+	// it in the queue. Without CPU sets, this is either the exclusive
+	// locker at the head or all shared lockers at the head. Keeping
+	// the queue order means a waiting locker is never starved by
+	// later ones.
+next:
+	for i, locker := range q {
+		for _, o := range q[:i] {
+			if !locker.compatible(o) {
+				continue next
 			}
-			wake(locker)
 		}
-	} else {
-		wake(q[0])
+		wake(locker)
+	}
+}
+
+// compatible reports whether l and o may hold the lock at the same
+// time: either both are shared, or they reserve disjoint CPU sets.
+func (l *locker) compatible(o *locker) bool {
+	if l.shared && o.shared {
+		return true
+	}
+	if l.cpus == nil || o.cpus == nil {
+		return false
+	}
+	for i, j := 0, 0; i < len(l.cpus) && j < len(o.cpus); {
+		switch {
+		case l.cpus[i] == o.cpus[j]:
+			return false
+		case l.cpus[i] < o.cpus[j]:
+			i++
+		default:
+			j++
+		}
 	}
+	return true
 }
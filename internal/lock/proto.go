@@ -1,35 +1,144 @@
 package lock
 
-import "encoding/gob"
+import "fmt"
 
-type perflockAction struct {
-	Action interface{}
+// ProtocolVersion is the version of the daemon protocol spoken by
+// this package.
+//
+// The protocol is a stream of JSON objects, one per line, exchanged
+// over the daemon's unix socket. The client sends requests and the
+// server replies to each of them with exactly one response. The
+// first request on a connection must be a "hello" carrying the
+// client's protocol version; the server answers with its own version
+// or with a "version" error if it cannot speak the client's version.
+//
+// For example, a complete session listing the lock queue is:
+//
+//	-> {"type":"hello","version":1}
+//	<- {"type":"hello","version":1}
+//	-> {"type":"list"}
+//	<- {"type":"list","list":["alice\tNov  7 19:59:51\tgo test ..."]}
+const ProtocolVersion = 1
+
+// Request types.
+const (
+	// typeHello negotiates the protocol version. The response
+	// carries the version of the server.
+	typeHello = "hello"
+
+	// typeAcquire acquires the lock. The response's OK field
+	// indicates whether or not the lock was acquired (which may be
+	// false for a non-blocking acquire). A blocking acquire does
+	// not respond until the lock is acquired. An acquire with a CPU
+	// set only excludes holders of overlapping CPU sets.
+	typeAcquire = "acquire"
+
+	// typeList returns the list of current and pending lock
+	// acquisitions.
+	typeList = "list"
+
+	// typeSetCPUFreq sets the CPU frequency and optionally the
+	// scaling governor of all CPUs, or of the CPUs reserved by the
+	// caller's acquire. The caller must hold the lock.
+	// The response carries the percent that was applied, the target
+	// frequency, the upper limit actually applied and the frequency
+	// achieved.
+	typeSetCPUFreq = "setcpufreq"
+
+	// typeNoTurbo disables frequency boosting (turbo) until the lock
+	// is released. The caller must hold the lock exclusively, for all
+	// CPUs.
+	typeNoTurbo = "noturbo"
+
+	// typeIsolate moves the process of a benchmark into a cgroup v2
+	// cpuset partition of the reserved CPUs, if the daemon is
+	// configured to isolate them. The caller must hold the lock
+	// exclusively, for a CPU set, and own the process. The response
+	// reports whether the CPUs are isolated from other tasks.
+	typeIsolate = "isolate"
+
+	// typeError is the type of a response reporting a failed
+	// request.
+	typeError = "error"
+)
+
+// request is a single request from a client.
+type request struct {
+	Type string `json:"type"`
+
+	// Version is the protocol version of the client (hello).
+	Version int `json:"version,omitempty"`
+
+	// Shared, NonBlocking and Msg describe the lock
+	// acquisition (acquire).
+	Shared      bool   `json:"shared,omitempty"`
+	NonBlocking bool   `json:"nonblocking,omitempty"`
+	Msg         string `json:"msg,omitempty"`
+	// CPUs is the list of CPUs to reserve, such as "4-7" (acquire).
+	// If empty, all CPUs are reserved.
+	CPUs string `json:"cpus,omitempty"`
+
+	// Percent indicates the percent to set the CPU frequency to
+	// between the lower and highest available frequencies
+	// (setcpufreq). If nil, the daemon's default is used, and if
+	// negative, the frequency range is left unchanged.
+	Percent *int `json:"percent,omitempty"`
+	// Governor is the scaling governor to switch to, such as
+	// "performance" or "userspace" (setcpufreq). If empty, the
+	// governor is left unchanged.
+	Governor string `json:"governor,omitempty"`
+	// EPP is the energy performance preference hint to set on
+	// drivers that support it, such as "performance" (setcpufreq).
+	EPP string `json:"epp,omitempty"`
+
+	// Pid is the process to move into the partition (isolate).
+	Pid int `json:"pid,omitempty"`
 }
 
-// actionAcquire acquires the lock. The response is a boolean
-// indicating whether or not the lock was acquired (which may be false
-// for a non-blocking acquire).
-type actionAcquire struct {
-	Shared      bool
-	NonBlocking bool
-	Msg         string
+// response is the server's reply to a single request. Type is the
+// type of the request, or "error" if the request failed.
+type response struct {
+	Type     string   `json:"type"`
+	Version  int      `json:"version,omitempty"`
+	OK       bool     `json:"ok,omitempty"`
+	List     []string `json:"list,omitempty"`
+	Percent  int      `json:"percent,omitempty"`
+	Target   int      `json:"target,omitempty"` // kHz
+	Limit    int      `json:"limit,omitempty"`  // kHz
+	Freq     int      `json:"freq,omitempty"`   // kHz
+	Isolated bool     `json:"isolated,omitempty"`
+	Error    *Error   `json:"error,omitempty"`
 }
 
-// actionList returns the list of current and pending lock
-// acquisitions as a []string.
-type actionList struct {
+// Error codes reported by the daemon.
+const (
+	// ErrProtocol reports a malformed or out of order request.
+	ErrProtocol = "protocol"
+	// ErrVersion reports an unsupported protocol version.
+	ErrVersion = "version"
+	// ErrUnknown reports an unknown request type.
+	ErrUnknown = "unknown"
+	// ErrNotHeld reports a request that requires holding the lock.
+	ErrNotHeld = "not-held"
+	// ErrDenied reports a user that is not allowed to use the daemon.
+	ErrDenied = "denied"
+	// ErrBusy reports an acquisition refused because the lock queue
+	// is full.
+	ErrBusy = "busy"
+	// ErrFailed reports a request that was valid but failed.
+	ErrFailed = "failed"
+)
+
+// Error is an error response from the daemon.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
-// actionSetCPUFreq sets the CPU frequency of all CPUs. The caller
-// must hold the lock.
-type actionSetCPUFreq struct {
-	// Percent indicates the percent to set the CPU cpuFreq to
-	// between the lower and highest available frequencies.
-	Percent int
+func (e *Error) Error() string {
+	return fmt.Sprintf("bench daemon: %s: %s", e.Code, e.Message)
 }
 
-func init() {
-	gob.Register(actionAcquire{})
-	gob.Register(actionList{})
-	gob.Register(actionSetCPUFreq{})
+func errorf(code, format string, args ...interface{}) *response {
+	return &response{Type: typeError, Error: &Error{code, fmt.Sprintf(format, args...)}}
 }
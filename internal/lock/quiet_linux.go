@@ -0,0 +1,180 @@
+//go:build linux
+// +build linux
+
+package lock
+
+import (
+	"fmt"
+	"os"
+)
+
+// quietCPUs takes the SMT siblings of the reserved CPUs offline and
+// disables their deep idle states, as configured, until the lock is
+// released. The original settings are journaled before they are
+// changed.
+func (s *Server) quietCPUs() error {
+	if !config.SMTOff && config.MaxIdleLatency == nil {
+		return nil
+	}
+	reserved := s.locker.cpus
+	if reserved == nil {
+		all, err := cpus.CPUs()
+		if err != nil {
+			return err
+		}
+		reserved = all
+	}
+	if config.SMTOff {
+		if err := s.offlineSiblings(reserved); err != nil {
+			return fmt.Errorf("taking SMT siblings offline: %v", err)
+		}
+	}
+	if config.MaxIdleLatency != nil {
+		if err := s.disableIdleStates(reserved, *config.MaxIdleLatency); err != nil {
+			return fmt.Errorf("disabling idle states: %v", err)
+		}
+	}
+	return nil
+}
+
+// offlineSiblings keeps only one CPU of each core of reserved online:
+// the CPUs the client asked for, so that it can run on them, or else
+// the first CPU of the core.
+func (s *Server) offlineSiblings(reserved []int) error {
+	// With all CPUs reserved, prefer the global switch, which the
+	// kernel applies to all cores at once.
+	if s.locker.cpus == nil {
+		control, err := cpus.SMTControl()
+		if err == nil && control == "on" {
+			if err := saveSMTControl(control); err != nil {
+				return fmt.Errorf("saving SMT control: %v", err)
+			}
+			s.oldSMTControl = control
+			return cpus.SetSMTControl("off")
+		} else if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	keep := make(map[int]bool)
+	for _, c := range s.requested {
+		keep[c] = true
+	}
+	var offline []int
+	seen := make(map[int]bool) // CPUs of the cores already handled
+	for _, c := range reserved {
+		if seen[c] {
+			continue
+		}
+		online, err := cpus.Online(c)
+		if err != nil {
+			return err
+		}
+		if !online {
+			continue
+		}
+		siblings, err := cpus.ThreadSiblings(c)
+		if err != nil {
+			return err
+		}
+		requested := false
+		for _, x := range siblings {
+			requested = requested || keep[x]
+		}
+		for i, x := range siblings {
+			seen[x] = true
+			if keep[x] || !requested && i == 0 {
+				continue
+			}
+			if !cpus.Hotpluggable(x) {
+				// Such as cpu0, which leaves the core with
+				// two online CPUs.
+				continue
+			}
+			if online, err := cpus.Online(x); err != nil || !online {
+				continue
+			}
+			offline = append(offline, x)
+		}
+	}
+	if len(offline) == 0 {
+		return nil
+	}
+	if err := saveOffline(offline); err != nil {
+		return fmt.Errorf("saving online CPUs: %v", err)
+	}
+	s.offline = offline
+	for _, c := range offline {
+		if err := cpus.SetOnline(c, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disableIdleStates disables the enabled idle states of the online
+// reserved CPUs whose exit latency exceeds maxLatency microseconds.
+func (s *Server) disableIdleStates(reserved []int, maxLatency int) error {
+	var disable []string
+	for _, c := range reserved {
+		if online, err := cpus.Online(c); err != nil || !online {
+			continue
+		}
+		states, err := cpus.IdleStates(c)
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			if st.Latency() <= maxLatency {
+				continue
+			}
+			disabled, err := st.Disabled()
+			if err != nil {
+				return err
+			}
+			if !disabled {
+				disable = append(disable, st.Path())
+			}
+		}
+	}
+	if len(disable) == 0 {
+		return nil
+	}
+	if err := saveIdleDisabled(disable); err != nil {
+		return fmt.Errorf("saving idle states: %v", err)
+	}
+	s.disabledIdle = disable
+	for _, path := range disable {
+		if err := cpus.SetIdleStateDisabled(path, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreQuietCPUs undoes quietCPUs.
+func (s *Server) restoreQuietCPUs() error {
+	return restoreQuiet(s.offline, s.oldSMTControl, s.disabledIdle)
+}
+
+// restoreQuiet brings the offline CPUs back online, restores the SMT
+// control setting if not empty, and enables the disabled idle
+// states.
+func restoreQuiet(offline []int, smtControl string, disabledIdle []string) error {
+	var err error
+	if smtControl != "" {
+		err = cpus.SetSMTControl(smtControl)
+	}
+	for _, c := range offline {
+		// Try to restore all of the CPUs, even if one fails.
+		if err1 := cpus.SetOnline(c, true); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	for _, path := range disabledIdle {
+		if err1 := cpus.SetIdleStateDisabled(path, false); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	return err
+}
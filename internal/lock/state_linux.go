@@ -0,0 +1,336 @@
+//go:build linux
+// +build linux
+
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.design/x/bench/internal/cpupower"
+)
+
+// savedState is the content of the state file. Each setting is only
+// recorded the first time it is changed, so that the file always
+// describes the settings from before any change that was never
+// restored. Lock holders with disjoint CPU sets record their
+// frequency domains side by side.
+type savedState struct {
+	CPUFreqs []savedCPUFreq `json:"cpufreqs,omitempty"`
+	PerfPct  *[2]int        `json:"perf_pct,omitempty"`
+	Boost    *bool          `json:"boost,omitempty"`
+	Cgroups  []string       `json:"cgroups,omitempty"`
+
+	// SMTControl is the original global SMT control setting, and
+	// Offline and IdleDisabled are the CPUs taken offline and the
+	// idle states disabled, which are restored by bringing them
+	// back online and enabling them.
+	SMTControl   string   `json:"smt_control,omitempty"`
+	Offline      []int    `json:"offline,omitempty"`
+	IdleDisabled []string `json:"idle_disabled,omitempty"`
+}
+
+// empty reports whether st records nothing.
+func (st *savedState) empty() bool {
+	return len(st.CPUFreqs) == 0 && st.PerfPct == nil && st.Boost == nil &&
+		len(st.Cgroups) == 0 && st.SMTControl == "" && len(st.Offline) == 0 && len(st.IdleDisabled) == 0
+}
+
+// savedCPUFreq is the on-disk form of cpuFreqSettings.
+type savedCPUFreq struct {
+	Path     string `json:"path"`
+	Min      int    `json:"min"`
+	Max      int    `json:"max"`
+	Governor string `json:"governor,omitempty"`
+	EPP      string `json:"epp,omitempty"`
+}
+
+// stateMu serializes updates of the state file.
+var stateMu sync.Mutex
+
+// saveCPUFreqs journals the settings in old to Statepath.
+func saveCPUFreqs(old []*cpuFreqSettings) error {
+	return updateState(func(st *savedState) {
+		saved := make(map[string]bool)
+		for _, g := range st.CPUFreqs {
+			saved[g.Path] = true
+		}
+		for _, g := range old {
+			if !saved[g.domain.Path()] {
+				st.CPUFreqs = append(st.CPUFreqs, savedCPUFreq{g.domain.Path(), g.min, g.max, g.governor, g.epp})
+			}
+		}
+	})
+}
+
+// savePerfPct journals the intel_pstate performance limits to
+// Statepath.
+func savePerfPct(min, max int) error {
+	return updateState(func(st *savedState) {
+		if st.PerfPct == nil {
+			st.PerfPct = &[2]int{min, max}
+		}
+	})
+}
+
+// saveBoost journals the boost setting to Statepath.
+func saveBoost(enabled bool) error {
+	return updateState(func(st *savedState) {
+		if st.Boost == nil {
+			st.Boost = &enabled
+		}
+	})
+}
+
+// saveSMTControl journals the global SMT control setting to
+// Statepath.
+func saveSMTControl(control string) error {
+	return updateState(func(st *savedState) {
+		if st.SMTControl == "" {
+			st.SMTControl = control
+		}
+	})
+}
+
+// saveOffline journals the CPUs about to be taken offline to
+// Statepath.
+func saveOffline(cpus []int) error {
+	return updateState(func(st *savedState) {
+		st.Offline = append(st.Offline, cpus...)
+	})
+}
+
+// saveIdleDisabled journals the idle states about to be disabled to
+// Statepath.
+func saveIdleDisabled(paths []string) error {
+	return updateState(func(st *savedState) {
+		st.IdleDisabled = append(st.IdleDisabled, paths...)
+	})
+}
+
+// forgetState removes the settings recorded in restored from the
+// state file once they have been restored. Frequency domains are
+// matched by path alone.
+func forgetState(restored *savedState) error {
+	return updateState(func(st *savedState) {
+		paths := make(map[string]bool)
+		for _, g := range restored.CPUFreqs {
+			paths[g.Path] = true
+		}
+		cpufreqs := st.CPUFreqs[:0]
+		for _, g := range st.CPUFreqs {
+			if !paths[g.Path] {
+				cpufreqs = append(cpufreqs, g)
+			}
+		}
+		st.CPUFreqs = cpufreqs
+		if restored.PerfPct != nil {
+			st.PerfPct = nil
+		}
+		if restored.Boost != nil {
+			st.Boost = nil
+		}
+		if restored.SMTControl != "" {
+			st.SMTControl = ""
+		}
+		st.Cgroups = removeStrings(st.Cgroups, restored.Cgroups)
+		st.IdleDisabled = removeStrings(st.IdleDisabled, restored.IdleDisabled)
+
+		online := make(map[int]bool)
+		for _, c := range restored.Offline {
+			online[c] = true
+		}
+		offline := st.Offline[:0]
+		for _, c := range st.Offline {
+			if !online[c] {
+				offline = append(offline, c)
+			}
+		}
+		st.Offline = offline
+	})
+}
+
+// removeStrings returns list without the elements of remove.
+func removeStrings(list, remove []string) []string {
+	removed := make(map[string]bool)
+	for _, x := range remove {
+		removed[x] = true
+	}
+	kept := list[:0]
+	for _, x := range list {
+		if !removed[x] {
+			kept = append(kept, x)
+		}
+	}
+	return kept
+}
+
+// saveCgroup journals the cgroup dir to Statepath before it is
+// created.
+func saveCgroup(dir string) error {
+	return updateState(func(st *savedState) {
+		st.Cgroups = append(st.Cgroups, dir)
+	})
+}
+
+// updateState applies update to the state file. The file is removed
+// once it records nothing.
+func updateState(update func(st *savedState)) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	st, err := readState()
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		st = new(savedState)
+	}
+	update(st)
+	if st.empty() {
+		err := os.Remove(Statepath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(Statepath), 0755); err != nil {
+		return err
+	}
+
+	// Write the new state file atomically so a crash cannot leave
+	// a truncated journal behind.
+	tmp := Statepath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, Statepath)
+}
+
+// readState reads the state file. It returns nil if there is none.
+func readState() (*savedState, error) {
+	data, err := ioutil.ReadFile(Statepath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	st := new(savedState)
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("%s: %v", Statepath, err)
+	}
+	return st, nil
+}
+
+// clearState removes the state file once the settings it records
+// have been restored.
+func clearState() error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	err := os.Remove(Statepath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// restoreSavedState restores the settings recorded in the state
+// file, if any, and removes it. It reports whether there was anything
+// to restore.
+func restoreSavedState() (bool, error) {
+	stateMu.Lock()
+	st, err := readState()
+	stateMu.Unlock()
+	if st == nil || err != nil {
+		return st != nil, err
+	}
+
+	for _, dir := range st.Cgroups {
+		// The daemon that created the cgroup knew where its
+		// processes came from; without it, only an empty cgroup
+		// is removed.
+		if err1 := removeCgroup(filepath.Dir(dir), dir, nil); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	// Bring CPUs back online before restoring their frequencies.
+	if err1 := restoreQuiet(st.Offline, st.SMTControl, st.IdleDisabled); err1 != nil && err == nil {
+		err = err1
+	}
+	if st.Boost != nil {
+		if err1 := cpus.SetBoost(*st.Boost); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	if len(st.CPUFreqs) > 0 {
+		if err1 := restoreSavedCPUFreqs(st.CPUFreqs); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	if st.PerfPct != nil {
+		if err1 := cpus.SetPerfPct(st.PerfPct[0], st.PerfPct[1]); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	if err != nil {
+		return true, err
+	}
+	return true, clearState()
+}
+
+func restoreSavedCPUFreqs(saved []savedCPUFreq) error {
+	domains, err := cpus.Domains()
+	if err != nil {
+		return err
+	}
+	byPath := make(map[string]*cpupower.Domain)
+	for _, d := range domains {
+		byPath[d.Path()] = d
+	}
+	for _, g := range saved {
+		d := byPath[g.Path]
+		if d == nil {
+			// Try to restore all of the domains, even if one
+			// has disappeared.
+			if err == nil {
+				err = fmt.Errorf("%s: unknown frequency domain", g.Path)
+			}
+			continue
+		}
+		if g.Governor != "" {
+			if err1 := d.SetGovernor(g.Governor); err1 != nil && err == nil {
+				err = err1
+			}
+		}
+		if g.EPP != "" {
+			if err1 := d.SetEnergyPerfPreference(g.EPP); err1 != nil && err == nil {
+				err = err1
+			}
+		}
+		if err1 := d.SetRange(g.Min, g.Max); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package lock
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd
+// socket activation.
+const listenFdsStart = 3
+
+// activationListener returns the listener passed by systemd socket
+// activation, or nil if the daemon was not socket activated.
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n == 0 {
+		return nil, nil
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("expected 1 socket from systemd, got %d", n)
+	}
+
+	// Don't pass the sockets on to child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	syscall.CloseOnExec(listenFdsStart)
+	f := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket passed by systemd: %v", err)
+	}
+	return l, nil
+}
+
+// checkActivationListener warns about the socket settings of cfg
+// that the socket l passed by systemd does not match. systemd creates
+// the socket from bench.socket, so they do not apply to it.
+func checkActivationListener(l net.Listener, cfg *Config) {
+	path := l.Addr().String()
+	if path != cfg.Socket {
+		log.Printf("socket %s passed by systemd is not the configured socket %s, set ListenStream= in bench.socket", path, cfg.Socket)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if mode := fi.Mode().Perm(); mode != cfg.socketMode {
+		log.Printf("socket %s passed by systemd has mode %04o instead of the configured socket_mode %s, set SocketMode= in bench.socket", path, mode, cfg.SocketMode)
+	}
+	if cfg.SocketGroup != "" {
+		g, err := user.LookupGroup(cfg.SocketGroup)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && strconv.Itoa(int(st.Gid)) != g.Gid {
+			log.Printf("socket %s passed by systemd is not owned by the configured socket_group %s, set SocketGroup= in bench.socket", path, cfg.SocketGroup)
+		}
+	}
+}
+
+// sdNotify sends a state change notification to systemd. It does
+// nothing if the daemon was not started by systemd with Type=notify.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if path[0] == '@' {
+		// Abstract socket.
+		path = "\x00" + path[1:]
+	}
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Printf("notifying systemd: %v", err)
+		return
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(state)); err != nil {
+		log.Printf("notifying systemd: %v", err)
+	}
+}
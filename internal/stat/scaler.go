@@ -17,6 +17,9 @@ func NewScaler(val float64, unit string) Scaler {
 	if hasBaseUnit(unit, "ns/op") || hasBaseUnit(unit, "ns/GC") {
 		return timeScaler(val)
 	}
+	if hasBaseUnit(unit, "J/op") {
+		return energyScaler(val)
+	}
 
 	var format string
 	var scale float64
@@ -107,6 +110,31 @@ func timeScaler(ns float64) Scaler {
 	}
 }
 
+// energyScaler scales joules to J, mJ, µJ or nJ.
+func energyScaler(j float64) Scaler {
+	scale, suffix := 1e9, "nJ"
+	switch {
+	case j >= 0.995:
+		scale, suffix = 1, "J"
+	case j >= 0.000995:
+		scale, suffix = 1e3, "mJ"
+	case j >= 0.000000995:
+		scale, suffix = 1e6, "µJ"
+	}
+	var format string
+	switch x := j * scale; {
+	case x >= 99.5:
+		format = "%.0f"
+	case x >= 9.95:
+		format = "%.1f"
+	default:
+		format = "%.2f"
+	}
+	return func(j float64) string {
+		return fmt.Sprintf(format+suffix, j*scale)
+	}
+}
+
 // hasBaseUnit reports whether s has unit unit.
 // For now, it reports whether s == unit or s ends in -unit.
 func hasBaseUnit(s, unit string) bool {
@@ -101,7 +101,7 @@ func (c *Collection) Tables() []*Table {
 							pct := ((new.Mean / old.Mean) - 1.0) * 100.0
 							row.PctDelta = pct
 							row.Delta = fmt.Sprintf("%+.2f%%", pct)
-							if pct < 0 == (table.Metric != "speed") { // smaller is better, except speeds
+							if pct < 0 != higherIsBetter[table.Metric] {
 								row.Change = +1
 							} else {
 								row.Change = -1
@@ -136,6 +136,14 @@ var metricSuffix = map[string]string{
 	"ns/GC": "time/GC",
 	"B/op":  "alloc/op",
 	"MB/s":  "speed",
+	"J/op":  "energy/op",
+}
+
+// higherIsBetter is the metrics for which an increase is an
+// improvement. Smaller is better for all others.
+var higherIsBetter = map[string]bool{
+	"speed": true,
+	"IPC":   true,
 }
 
 // metricOf returns the name of the metric with the given unit.
@@ -0,0 +1,55 @@
+package stat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTablesChange(t *testing.T) {
+	// results returns n results of a benchmark measuring v in
+	// unit, slightly apart so that they have some variance.
+	results := func(v float64, unit string) string {
+		var b strings.Builder
+		for i := 0; i < 6; i++ {
+			fmt.Fprintf(&b, "BenchmarkA 1000 %g %s\n", v*(1+float64(i)/100), unit)
+		}
+		return b.String()
+	}
+	tests := []struct {
+		unit     string
+		old, new float64
+		change   int
+	}{
+		{"ns/op", 100, 50, +1},
+		{"ns/op", 50, 100, -1},
+		{"MB/s", 100, 200, +1},
+		{"MB/s", 200, 100, -1},
+		{"IPC", 1, 2, +1},
+		{"IPC", 2, 1, -1},
+		{"instructions/op", 100, 200, -1},
+		{"J/op", 2, 1, +1},
+	}
+	for _, tt := range tests {
+		c := &Collection{Alpha: 0.05}
+		if err := c.AddData("old", []byte(results(tt.old, tt.unit))); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddData("new", []byte(results(tt.new, tt.unit))); err != nil {
+			t.Fatal(err)
+		}
+		var row *Row
+		for _, table := range c.Tables() {
+			if len(table.Rows) == 1 && metricOf(tt.unit) == table.Metric {
+				row = table.Rows[0]
+			}
+		}
+		if row == nil {
+			t.Errorf("%s: no table", tt.unit)
+			continue
+		}
+		if row.Change != tt.change {
+			t.Errorf("%s from %g to %g: change %+d, want %+d", tt.unit, tt.old, tt.new, row.Change, tt.change)
+		}
+	}
+}
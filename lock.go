@@ -0,0 +1,65 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// runLocked runs the command args under the performance lock and
+// exits with the exit status of the command.
+func runLocked(args []string) {
+	c := acquireLock(shellEscapeList(args))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// SIGINT and SIGQUIT from the terminal are delivered to the
+	// whole foreground process group, so ignore them here and let
+	// the command decide. Signals sent only to bench are forwarded.
+	signal.Notify(make(chan os.Signal), os.Interrupt, syscall.SIGQUIT)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGHUP)
+
+	if err := startPinned(c, cmd); err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		for sig := range sigCh {
+			cmd.Process.Signal(sig)
+		}
+	}()
+	err := cmd.Wait()
+	signal.Stop(sigCh)
+
+	// Release the lock before exiting, os.Exit skips deferred calls.
+	if c != nil {
+		c.Close()
+	}
+	os.Exit(exitStatus(err))
+}
+
+// exitStatus returns the exit status of a command that finished with
+// the given error from Wait. A command killed by a signal reports
+// 128 plus the signal number, as shells do.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		log.Print(err)
+		return 1
+	}
+	status := ee.Sys().(syscall.WaitStatus)
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
@@ -11,25 +11,46 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"golang.design/x/bench/internal/benchfmt"
+	"golang.design/x/bench/internal/cpupower"
 	"golang.design/x/bench/internal/lock"
 	"golang.design/x/bench/internal/stat"
 	"golang.design/x/bench/internal/term"
 )
 
 func usage() {
-	fmt.Fprintf(os.Stderr, `usage: bench [options]
+	fmt.Fprintf(os.Stderr, `usage: bench [options] [packages] [-- go test flags]
+       bench [options] old.txt [new.txt]
+       bench -lock [options] -- command [args...]
+options for project configuration:
+	-profile name
+		use the flags of the named profile of the project
+		configuration .bench.json, found in the working directory
+		or its parents, which sets defaults for all flags and per
+		package go test flags (default unset)
+
 options for daemon usage:
 	-daemon
 		run bench service
 	-list
 		print current and pending commands
+	-lock
+		run the command after -- under the performance lock
+	-socket path
+		connect to the bench daemon at path (default $BENCH_SOCKET
+		or "/var/run/bench.socket")
+	-config file
+		read the daemon configuration from file
+		(default "/etc/bench/daemon.json")
 
 options for significant tests:
 	-delta-test test
@@ -42,6 +63,11 @@ options for significant tests:
 		split benchmarks by labels (default "pkg,goos,goarch")
 	-sort order
 		sort by order: [-]delta, [-]name, none (default "none")
+	-profile-regressions
+		rerun the benchmarks that got worse between old.txt and
+		new.txt once on each side's commit with CPU and memory
+		profiling, and save the profiles and a pprof -diff_base
+		summary next to the result files (default false)
 
 options for running benchmarks:
 	-v go test
@@ -54,13 +80,51 @@ options for running benchmarks:
 		the -benchtime flag from go test (default unset)
 	-cpuprocs go test
 		the -cpu flag to go test (default unset)
+	-matrix spec
+		run the benchmarks with each combination of parameters,
+		such as 'GOGC=50,100,off;tags=a,b', where dimensions are
+		separated by ; and are environment variables or tags, and
+		compare the combinations side by side; quote values that
+		contain , or ;, as in GODEBUG="a=1,b=2" (default unset)
+	-toolchains go1.22.5,go1.23.0
+		run the benchmarks with each local Go toolchain, given as a
+		GOROOT or a release installed in ~/sdk or PATH, such as by
+		golang.org/dl, taking turns to run the benchmarks once, and
+		compare them side by side (default unset)
+	-perf
+		count hardware events of each benchmark with perf_event_open,
+		such as instructions/op and IPC; the counts cover all runs of
+		a benchmark but are divided by the iterations of the last, so
+		they overstate the events per op (default false, Linux only)
+	-require-quiet
+		refuse to run benchmarks if the machine is busy, hot or
+		under memory pressure (default false, only warn)
+	-- flags
+		pass all flags after -- to go test verbatim, such as
+		-benchmem, -tags or -args followed by flags of the test
+		binary, and record them as the test-flags label
 
 options for performance locking
 	-shared
 		acquire lock in shared mode (default exclusive mode)
 	-cpufreq percent
-		set CPU frequency to percent between the min and max (default 90)
-		while running command, or "none" for no adjustment
+		set CPU frequency to percent between the min and max
+		while running command, "none" for no adjustment, or
+		"default" for the daemon's default (default "default")
+	-governor name
+		set the CPU scaling governor, such as performance or
+		userspace, while running command (default unset)
+	-epp preference
+		set the CPU energy performance preference, such as
+		performance, while running command on intel_pstate and
+		amd_pstate drivers in active mode (default unset)
+	-noturbo
+		disable turbo boost while running command, requires
+		exclusive mode (default false)
+	-cpus list
+		reserve only the CPUs in list, such as 4-7, and run
+		command on them; frequency settings only change those
+		CPUs (default all CPUs)
 `)
 	os.Exit(2)
 }
@@ -68,6 +132,9 @@ options for performance locking
 var (
 	flagDaemon *bool
 	flagList   *bool
+	flagLock   *bool
+	flagSocket *string
+	flagConfig *string
 
 	flagDeltaTest *string
 	flagAlpha     *float64
@@ -75,14 +142,29 @@ var (
 	flagSplit     *string
 	flagSort      *string
 
-	flagShared  *bool
-	flagCPUFreq *lock.CpufreqFlag
+	flagProfileRegressions *bool
+	flagProfile            *string
+	flagMatrix             *string
+	flagToolchains         *string
+
+	flagShared   *bool
+	flagCPUFreq  *lock.CpufreqFlag
+	flagGovernor *string
+	flagEPP      *string
+	flagNoTurbo  *bool
+	flagCPUs     *string
 
 	flagVerbose  *bool
 	flagName     *string
 	flagCount    *int
 	flagTime     *string
 	flagCPUProcs *string
+
+	flagRequireQuiet *bool
+	flagPerf         *bool
+
+	// testFlags are the flags after --, passed to go test verbatim.
+	testFlags []string
 )
 
 func main() {
@@ -93,6 +175,9 @@ func main() {
 	// daemon args
 	flagDaemon = flag.Bool("daemon", false, "run bench service")
 	flagList = flag.Bool("list", false, "print current and pending commands")
+	flagLock = flag.Bool("lock", false, "run the command after -- under the performance lock")
+	flagSocket = flag.String("socket", "", "connect to the bench daemon at `path`")
+	flagConfig = flag.String("config", "", "read the daemon configuration from `file`")
 
 	// benchstat args
 	flagDeltaTest = flag.String("delta-test", "utest", "significance `test` to apply to delta: utest, ttest, or none")
@@ -100,11 +185,16 @@ func main() {
 	flagGeomean = flag.Bool("geomean", false, "print the geometric mean of each file")
 	flagSplit = flag.String("split", "pkg,goos,goarch", "split benchmarks by `labels`")
 	flagSort = flag.String("sort", "none", "sort by `order`: [-]delta, [-]name, none")
+	flagProfileRegressions = flag.Bool("profile-regressions", false, "profile the benchmarks that got worse between old.txt and new.txt")
 
 	// perflock flags
 	flagShared = flag.Bool("shared", false, "acquire lock in shared mode (default exclusive mode)")
-	flagCPUFreq = &lock.CpufreqFlag{Percent: 90}
-	flag.Var(flagCPUFreq, "cpufreq", "set CPU frequency to `percent` between the min and max\n\twhile running command, or \"none\" for no adjustment")
+	flagCPUFreq = &lock.CpufreqFlag{Percent: lock.CPUFreqDefault}
+	flag.Var(flagCPUFreq, "cpufreq", "set CPU frequency to `percent` between the min and max\n\twhile running command, \"none\" for no adjustment, or \"default\"")
+	flagGovernor = flag.String("governor", "", "set the CPU scaling `governor` while running command")
+	flagEPP = flag.String("epp", "", "set the CPU energy performance `preference` while running command")
+	flagNoTurbo = flag.Bool("noturbo", false, "disable turbo boost while running command")
+	flagCPUs = flag.String("cpus", "", "reserve only the CPUs in `list` and run command on them")
 
 	// go test args
 	flagVerbose = flag.Bool("v", false, "the -v flag from `go test`, (default false)")
@@ -112,18 +202,84 @@ func main() {
 	flagCount = flag.Int("count", 10, "the -count flag from `go test` (default 10)")
 	flagTime = flag.String("time", "", "the -benchtime flag from `go test` (default unset)")
 	flagCPUProcs = flag.String("cpuprocs", "", "the -cpu flag to `go test` (default unset)")
-	flag.Parse()
+	flagPerf = flag.Bool("perf", false, "count hardware events of each benchmark")
+	flagRequireQuiet = flag.Bool("require-quiet", false, "refuse to run benchmarks if the machine is busy")
+	flagProfile = flag.String("profile", "", "use the named `profile` of the project configuration")
+	flagMatrix = flag.String("matrix", "", "run benchmarks with each combination of the parameters in `spec`")
+	flagToolchains = flag.String("toolchains", "", "run benchmarks with each of the comma-separated Go `toolchains`")
+
+	// Everything after -- is passed to go test, or is the command to
+	// run with -lock. Split it off before parsing, because the flag
+	// package drops the -- itself.
+	cmdArgs := os.Args[1:]
+	for i, arg := range cmdArgs {
+		if arg == "--" {
+			cmdArgs, testFlags = cmdArgs[:i], cmdArgs[i+1:]
+			break
+		}
+	}
+	flag.CommandLine.Parse(cmdArgs)
+
+	// Flags not given take their defaults from the project
+	// configuration, if any.
+	if wd, err := os.Getwd(); err == nil {
+		if file := findProjectConfig(wd); file != "" {
+			if project, err = loadProjectConfig(file); err != nil {
+				log.Fatal(err)
+			}
+			if err := project.apply(*flagProfile); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *flagProfile != "" {
+		if project == nil {
+			log.Fatalf("-profile %s: no %s found", *flagProfile, projectFile)
+		}
+		resultLabels["profile"] = *flagProfile
+	}
+
+	if *flagCPUs != "" {
+		list, err := cpupower.ParseCPUList(*flagCPUs)
+		if err != nil || len(list) == 0 {
+			log.Fatalf("invalid -cpus %q", *flagCPUs)
+		}
+		benchCPUs = list
+	}
+	if *flagMatrix != "" {
+		points, err := parseMatrix(*flagMatrix)
+		if err != nil {
+			log.Fatalf("invalid -matrix: %v", err)
+		}
+		matrix = points
+	}
+	if *flagToolchains != "" {
+		tcs, err := parseToolchains(*flagToolchains)
+		if err != nil {
+			log.Fatalf("invalid -toolchains: %v", err)
+		}
+		for _, t := range tcs {
+			log.Print(term.Gray(fmt.Sprintf("toolchain %s: %s", t.name, t.version)))
+		}
+		toolchains = tcs
+	}
+
+	if *flagSocket != "" {
+		lock.Socketpath = *flagSocket
+	} else if s := os.Getenv("BENCH_SOCKET"); s != "" {
+		lock.Socketpath = s
+	}
 
 	if *flagDaemon {
-		if flag.NArg() > 0 {
+		if flag.NArg() > 0 || testFlags != nil {
 			flag.Usage()
 			os.Exit(2)
 		}
-		lock.RunDaemon()
+		runDaemon()
 		return
 	}
 	if *flagList {
-		if flag.NArg() > 0 {
+		if flag.NArg() > 0 || testFlags != nil {
 			flag.Usage()
 			os.Exit(2)
 		}
@@ -131,7 +287,10 @@ func main() {
 		if c == nil {
 			log.Fatal("Is the bench daemon running?")
 		}
-		list := c.List()
+		list, err := c.List()
+		if err != nil {
+			log.Fatal(err)
+		}
 		if len(list) == 0 {
 			log.Println("daemon is running but no running benchmarks.")
 			return
@@ -142,57 +301,196 @@ func main() {
 		return
 	}
 
-	if flag.NArg() > 0 {
+	if *flagLock {
+		command := append(flag.Args(), testFlags...)
+		if len(command) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		runLocked(command)
+		return
+	}
+
+	if flag.NArg() > 0 && isResultFiles(flag.Args()) {
 		runCompare()
 		return
 	}
+	if *flagProfileRegressions {
+		log.Fatal("-profile-regressions requires old.txt and new.txt")
+	}
 
 	// prepare go test command
+	args, _ := goTestArgs(nil)
+
+	// Record the commit, so that -profile-regressions can rerun the
+	// benchmarks on it.
+	if commit := gitCommit(); commit != "" {
+		resultLabels["commit"] = commit
+	}
+
+	// Ignore SIGQUIT until runBench forwards it, with interrupts, to
+	// go test, which runs in a process group of its own.
+	signal.Notify(make(chan os.Signal), syscall.SIGQUIT)
+
+	// acquire lock
+	if testFlags != nil {
+		resultLabels["test-flags"] = shellEscapeList(testFlags)
+	}
+	c := acquireLock(strings.Join(testCommand(args, flag.Args()...), " "))
+	if c != nil {
+		defer c.Close()
+	}
+
+	// Check that nothing else disturbs the benchmarks.
+	preflight()
+
+	// run bench
+	runBench(c, flag.Args())
+}
+
+// goTestArgs returns the go test command for the go test flags of
+// bench, where overrides, by flag name, take precedence over the
+// flags, and the number of times it runs each benchmark.
+func goTestArgs(overrides map[string]string) ([]string, int) {
+	value := func(name string) string {
+		if v, ok := overrides[name]; ok {
+			return v
+		}
+		return flag.Lookup(name).Value.String()
+	}
 	args := []string{
 		"go",
 		"test",
 		"-run=^$",
 	}
-	if *flagVerbose {
+	if v, _ := strconv.ParseBool(value("v")); v {
 		args = append(args, "-v")
 	}
-	args = append(args, fmt.Sprintf("-bench=%s", *flagName))
-	if *flagCount <= 0 {
-		*flagCount = 10
+	args = append(args, fmt.Sprintf("-bench=%s", value("name")))
+	count, err := strconv.Atoi(value("count"))
+	if err != nil || count <= 0 {
+		count = 10
 	}
-	args = append(args, fmt.Sprintf("-count=%d", *flagCount))
-	if *flagTime != "" {
-		args = append(args, fmt.Sprintf("-benchtime=%s", *flagTime))
+	args = append(args, fmt.Sprintf("-count=%d", count))
+	if t := value("time"); t != "" {
+		args = append(args, fmt.Sprintf("-benchtime=%s", t))
 	}
-	if *flagCPUProcs != "" {
-		args = append(args, fmt.Sprintf("-cpu=%s", *flagCPUProcs))
+	if procs := value("cpuprocs"); procs != "" {
+		args = append(args, fmt.Sprintf("-cpu=%s", procs))
 	}
+	return args, count
+}
 
-	// acquire lock
+// isResultFiles reports whether args name result files to compare
+// rather than packages to benchmark.
+func isResultFiles(args []string) bool {
+	for _, arg := range args {
+		if strings.HasSuffix(arg, ".txt") {
+			continue
+		}
+		if fi, err := os.Stat(arg); err != nil || !fi.Mode().IsRegular() {
+			return false
+		}
+	}
+	return true
+}
+
+// runDaemon runs the bench daemon. The configuration is read from
+// the -config file, or from the default configuration file if it
+// exists, and the -socket flag overrides the configured socket.
+func runDaemon() {
+	file := *flagConfig
+	if file == "" {
+		file = lock.Configpath
+	}
+	cfg, err := lock.LoadConfig(file)
+	if os.IsNotExist(err) && *flagConfig == "" {
+		cfg, err = lock.DefaultConfig(), nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *flagSocket != "" {
+		cfg.Socket = *flagSocket
+	}
+	lock.RunDaemon(cfg)
+}
+
+// acquireLock acquires the performance lock from the bench daemon
+// for the command described by msg and applies the requested cpufreq
+// setting. The lock is held until the returned client is closed. It
+// returns nil if the daemon is not running.
+func acquireLock(msg string) *lock.Client {
 	c := lock.NewClient()
 	if c == nil {
 		log.Printf(term.Red("run benchmarks without performance locking..."))
-	} else {
-		if !c.Acquire(*flagShared, true, strings.Join(args, " ")) {
-			list := c.List()
-			log.Printf("Waiting for lock...\n")
-			for _, l := range list {
-				log.Println(l)
-			}
-			c.Acquire(*flagShared, false, strings.Join(args, " "))
+		return nil
+	}
+	ok, err := c.Acquire(*flagShared, true, *flagCPUs, msg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		list, err := c.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Waiting for lock...\n")
+		for _, l := range list {
+			log.Println(l)
 		}
-		if !*flagShared && flagCPUFreq.Percent >= 0 {
-			c.SetCPUFreq(flagCPUFreq.Percent)
-			log.Print(term.Gray(fmt.Sprintf("run benchmarks under %d%% cpufreq...", flagCPUFreq.Percent)))
+		if _, err := c.Acquire(*flagShared, false, *flagCPUs, msg); err != nil {
+			log.Fatal(err)
 		}
 	}
+	if !*flagShared && (flagCPUFreq.Percent != lock.CPUFreqNone || *flagGovernor != "" || *flagEPP != "") {
+		freq, err := c.SetCPUFreq(flagCPUFreq.Percent, *flagGovernor, *flagEPP)
+		if err != nil {
+			log.Print(term.Orange(fmt.Sprintf("failed to set cpufreq: %v", err)))
+		} else {
+			var settings []string
+			if freq.Percent >= 0 {
+				pinnedFreq = freq.Target
+				settings = append(settings, fmt.Sprintf("%d%% cpufreq (%s)", freq.Percent, formatFreq(freq.Target)))
+			}
+			if *flagGovernor != "" {
+				settings = append(settings, fmt.Sprintf("%s governor", *flagGovernor))
+			}
+			if *flagEPP != "" {
+				settings = append(settings, fmt.Sprintf("%s energy preference", *flagEPP))
+			}
+			log.Print(term.Gray(fmt.Sprintf("run benchmarks under %s...", strings.Join(settings, ", "))))
 
-	// Ignore SIGINT and SIGQUIT so they pass through to the
-	// child.
-	signal.Notify(make(chan os.Signal), os.Interrupt, syscall.SIGQUIT)
-
-	// run bench
-	runBench(args)
+			// The driver may clamp the requested frequency, and
+			// the hardware may not reach it.
+			far := func(f int) bool {
+				return math.Abs(float64(f-freq.Target)) > 0.05*float64(freq.Target)
+			}
+			if freq.Target > 0 && far(freq.Limit) {
+				log.Print(term.Orange(fmt.Sprintf("CPU frequency is limited to %s instead of the requested %s",
+					formatFreq(freq.Limit), formatFreq(freq.Target))))
+			} else if freq.Target > 0 && freq.Freq > 0 && far(freq.Freq) {
+				log.Print(term.Orange(fmt.Sprintf("CPU frequency is %s instead of the requested %s",
+					formatFreq(freq.Freq), formatFreq(freq.Target))))
+			}
+		}
+	}
+	if benchCPUs != nil {
+		resultLabels["cpus"] = cpupower.FormatCPUList(benchCPUs)
+	}
+	if *flagNoTurbo {
+		if *flagShared {
+			log.Print(term.Orange("cannot disable turbo boost in shared mode"))
+		} else if benchCPUs != nil {
+			log.Print(term.Orange("cannot disable turbo boost with -cpus, it affects all CPUs"))
+		} else if err := c.DisableTurbo(); err != nil {
+			log.Print(term.Orange(fmt.Sprintf("failed to disable turbo boost: %v", err)))
+		} else {
+			resultLabels["noturbo"] = "true"
+			log.Print(term.Gray("run benchmarks with turbo boost disabled..."))
+		}
+	}
+	return c
 }
 
 // shellEscape escapes a single shell token.
@@ -230,6 +528,12 @@ var deltaTestNames = map[string]stat.DeltaTest{
 }
 
 func runCompare() {
+	if *flagProfileRegressions && flag.NArg() != 2 {
+		log.Fatal("-profile-regressions requires old.txt and new.txt")
+	}
+	if testFlags != nil && !*flagProfileRegressions {
+		log.Fatal("go test flags after -- require -profile-regressions when comparing results")
+	}
 	c := &stat.Collection{
 		Alpha:      *flagAlpha,
 		AddGeoMean: *flagGeomean,
@@ -254,11 +558,302 @@ func runCompare() {
 	var buf bytes.Buffer
 	stat.FormatText(&buf, tables)
 	os.Stdout.Write(buf.Bytes())
+
+	if *flagProfileRegressions {
+		profileRegressions(tables, flag.Arg(0), flag.Arg(1))
+	}
+}
+
+// runBench runs the benchmarks of pkgs, or of the package in the
+// working directory if none, with the go test command args, one
+// package at a time. The results of all packages are saved to a single
+// file, and a failing package does not discard the others.
+//
+// If bench is interrupted, the signal is forwarded to go test and no
+// further packages are run. The complete results so far are saved and
+// marked as partial, and bench exits after releasing the lock.
+//
+// If go test fails, bench still saves the results of the benchmarks
+// that succeeded, lists the failures, and exits with the exit status
+// of go test.
+func runBench(c *lock.Client, pkgs []string) {
+	interrupts := make(chan os.Signal, 1)
+	// SIGQUIT makes the test binary dump its goroutines, such as
+	// those of a hung benchmark.
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(interrupts)
+
+	// Without packages, run go test without package arguments, as
+	// before, but look up the package for its project settings.
+	pkgArgs := len(pkgs) > 0
+	if !pkgArgs {
+		pkgs = []string{"."}
+		if project == nil || len(project.Packages) == 0 {
+			pkgs = []string{""}
+		}
+	}
+	if pkgs[0] != "" {
+		list, err := listTestPackages(pkgs)
+		switch {
+		case err == nil:
+			pkgs = list
+		case pkgArgs:
+			log.Fatal(err)
+		default:
+			// Let go test report the problem.
+			pkgs = []string{""}
+		}
+		if len(pkgs) == 0 {
+			log.Print("no packages with test files")
+			return
+		}
+	}
+	// Run each package with all -toolchains and points of the -matrix
+	// one after the other, so that they are compared under similar
+	// conditions. Toolchains take turns running each benchmark once,
+	// so that a change of the conditions affects them alike.
+	tcs := []*toolchain{nil}
+	points := []matrixPoint{nil}
+	var columns []string
+	if toolchains != nil {
+		tcs = toolchains
+		columns = append(columns, "toolchain")
+	}
+	if matrix != nil {
+		points = matrix
+		columns = append(columns, matrixLabels(points)...)
+	}
+	var runs []benchRun
+	for _, pkg := range pkgs {
+		var flags map[string]string
+		if project != nil && pkg != "" {
+			flags = project.packageFlags(pkg)
+		}
+		rounds := 1
+		if toolchains != nil {
+			_, rounds = goTestArgs(flags)
+			once := map[string]string{"count": "1"}
+			for name, v := range flags {
+				if name != "count" {
+					once[name] = v
+				}
+			}
+			flags = once
+		}
+		for round := 0; round < rounds; round++ {
+			for _, t := range tcs {
+				for _, point := range points {
+					args, count := goTestArgs(flags)
+					if t != nil {
+						args[0] = t.goCmd
+					}
+					args = append(args, point.flags()...)
+					if pkgArgs {
+						args = testCommand(args, pkg)
+					} else {
+						args = testCommand(args)
+					}
+					runs = append(runs, benchRun{pkg: pkg, toolchain: t, point: point, args: args, count: count})
+				}
+			}
+		}
+	}
+
+	// Watch for throttling while the benchmarks run.
+	mon := startMonitor(benchCPUs)
+
+	var results []byte
+	var failures []string // output lines reporting failures
+	var failed []string   // packages that failed
+	nfailed := 0          // runs that failed
+	var interrupted os.Signal
+	status := 0
+	for i, run := range runs {
+		select {
+		case interrupted = <-interrupts:
+		default:
+		}
+		if interrupted != nil {
+			break
+		}
+		if len(runs) > 1 {
+			log.Print(term.Gray(fmt.Sprintf("[%d/%d] %s", i+1, len(runs), run)))
+		}
+		out, sig, err := runTest(c, run, interrupts)
+		results = append(results, formatLabels(run.labels())...)
+		results = append(results, out...)
+		for _, f := range failureLines(out) {
+			if !contains(failures, f) {
+				failures = append(failures, f)
+			}
+		}
+		if sig != nil {
+			interrupted = sig
+			break
+		}
+		if err != nil {
+			name := run.String()
+			if name == "" {
+				name = "benchmarks"
+			}
+			log.Print(term.Red(fmt.Sprintf("%s failed: %v", name, err)))
+			nfailed++
+			// Runs repeat in rounds with -toolchains.
+			if !contains(failed, name) {
+				failed = append(failed, name)
+			}
+			if status == 0 {
+				status = exitStatus(err)
+			}
+		}
+	}
+	mon.Stop()
+	if len(runs) > 1 && len(failed) > 0 {
+		log.Print(term.Red(fmt.Sprintf("%d of %d runs failed: %s", nfailed, len(runs), strings.Join(failed, ", "))))
+	}
+	for _, f := range failures {
+		log.Print(term.Red(f))
+	}
+
+	if interrupted != nil {
+		log.Print(term.Orange(fmt.Sprintf("interrupted by %v, keeping the results so far", interrupted)))
+		status = 1
+		if s, ok := interrupted.(syscall.Signal); ok {
+			status = 128 + int(s)
+		}
+		results = dropIncomplete(results)
+		resultLabels["partial"] = "true"
+	}
+	if status != 0 {
+		defer exitReleased(c, status)
+	}
+
+	// do nothing if no benchmarks were ran.
+	if !hasResults(results) {
+		return
+	}
+
+	// Record the settings the benchmarks ran under as labels.
+	results = append(formatLabels(resultLabels), results...)
+
+	// Note that we should avoid using : in filename, because it is not
+	// supported on Windows file systems.
+	fname := "bench-" + time.Now().Format("2006-01-02-15-04-05") + ".txt"
+	err := ioutil.WriteFile(fname, results, 0644)
+	if err != nil {
+		// try again, maybe the user was too fast?
+		err = ioutil.WriteFile(fname, results, 0644)
+		if err != nil {
+			log.Fatal("cannot save benchmark result to your disk.")
+		}
+	}
+	log.Printf("results are saved to file: ./%s\n\n", fname)
+
+	computeStat(results, columns)
+}
+
+// dropIncomplete drops the output of benchmarks that were cut off from
+// the go test output results: their names, printed before they ran,
+// followed by anything but their results.
+func dropIncomplete(results []byte) []byte {
+	var out []byte
+	for {
+		i := bytes.IndexByte(results, '\n')
+		if i < 0 {
+			// The last line is cut off, even if it looks complete.
+			return out
+		}
+		line := results[:i+1]
+		results = results[i+1:]
+		if !bytes.HasPrefix(line, []byte("Benchmark")) || isResultLine(line) {
+			out = append(out, line...)
+		}
+	}
+}
+
+// exitReleased releases the lock held by c, if any, and exits with
+// status.
+func exitReleased(c *lock.Client, status int) {
+	// os.Exit skips deferred calls.
+	if c != nil {
+		c.Close()
+	}
+	os.Exit(status)
+}
+
+// A benchRun is a go test command benchmarking a package.
+type benchRun struct {
+	pkg       string      // import path, or "" if unknown
+	toolchain *toolchain  // from -toolchains, or nil for the go in PATH
+	point     matrixPoint // parameters from -matrix
+	args      []string
+	count     int // runs of each benchmark
+}
+
+// env returns the environment variables set for r, as name=value.
+func (r benchRun) env() []string {
+	var env []string
+	if r.toolchain != nil {
+		env = r.toolchain.env()
+	}
+	return append(env, r.point.env()...)
+}
+
+// labels returns the result labels of the parameters of r.
+func (r benchRun) labels() benchfmt.Labels {
+	labels := r.point.labels()
+	if r.toolchain != nil {
+		labels["toolchain"] = r.toolchain.version
+	}
+	return labels
+}
+
+func (r benchRun) String() string {
+	var s []string
+	if r.pkg != "" {
+		s = append(s, r.pkg)
+	}
+	if r.toolchain != nil {
+		s = append(s, r.toolchain.name)
+	}
+	if len(r.point) > 0 {
+		s = append(s, r.point.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// testCommand returns the go test command args for pkgs with the
+// flags after -- appended. They come last, so that they override the
+// flags of bench and may end with -args.
+func testCommand(args []string, pkgs ...string) []string {
+	cmd := append(args[:len(args):len(args)], pkgs...)
+	return append(cmd, testFlags...)
 }
 
-func runBench(args []string) {
-	log.Print(strings.Join(args, " "))
+// listTestPackages returns the import paths of the packages matching
+// patterns that have test files.
+func listTestPackages(patterns []string) ([]string, error) {
+	args := append([]string{"list", "-f", "{{if or .TestGoFiles .XTestGoFiles}}{{.ImportPath}}{{end}}"}, patterns...)
+	cmd := exec.Command("go", args...)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %v", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// runTest runs the go test command args, copying its output, and
+// returns its standard output with the results of the meters added.
+// Signals received from interrupts while it runs are forwarded to it,
+// and the first one is returned.
+func runTest(c *lock.Client, run benchRun, interrupts <-chan os.Signal) ([]byte, os.Signal, error) {
+	args := run.args
+	log.Print(strings.Join(append(run.point.env(), args...), " "))
 	cmd := exec.Command(args[0], args[1:]...)
+	if env := run.env(); env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		log.Fatal(err)
@@ -267,6 +862,29 @@ func runBench(args []string) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	setProcessGroup(cmd)
+	meters := new(annotator)
+	if m := newEnergyMeter(); m != nil {
+		meters.meters = append(meters.meters, m)
+	}
+	var perf *perfMeter
+	if *flagPerf {
+		perf, err = newPerfMeter()
+		if err != nil {
+			log.Print(term.Orange(fmt.Sprintf("run benchmarks without hardware counters: %v", err)))
+		} else {
+			defer perf.close()
+			meters.meters = append(meters.meters, perf)
+		}
+	}
+	// Parse the output as it arrives to show the progress.
+	prog := newProgress(run.count)
+	pr, pw := io.Pipe()
+	watched := make(chan struct{})
+	go func() {
+		prog.watch(pr)
+		close(watched)
+	}()
 	doneCh := make(chan []byte)
 	go func() {
 		data := []byte{}
@@ -277,12 +895,17 @@ func runBench(args []string) {
 				if err != io.EOF {
 					fmt.Fprintf(os.Stderr, "%v", err)
 				}
+				pw.Close()
+				<-watched
+				prog.done()
 				doneCh <- data
 				close(doneCh)
 				return
 			}
-			fmt.Fprintf(os.Stdout, string(buf[:n]))
-			data = append(data, buf[:n]...)
+			out := meters.annotate(buf[:n])
+			pw.Write(out)
+			prog.stdout(out)
+			data = append(data, out...)
 		}
 	}()
 	errCh := make(chan error)
@@ -299,50 +922,62 @@ func runBench(args []string) {
 				close(errCh)
 				return
 			}
-			fmt.Fprintf(os.Stderr, string(buf[:n]))
+			prog.stderr(buf[:n])
 		}
 	}()
 
-	err = cmd.Start()
-	switch err := err.(type) {
-	case *exec.ExitError:
-		status := err.Sys().(syscall.WaitStatus)
-		if status.Exited() {
-			os.Exit(status.ExitStatus())
-		}
+	err = startPinned(c, cmd)
+	if perf != nil {
+		perf.detach()
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	var results []byte
-	select {
-	case err = <-errCh:
-		if err != nil { // benchmark was interrupted or not success, exit.
-			os.Exit(2)
-			return
+	stop := make(chan struct{})
+	forwarded := make(chan os.Signal, 1)
+	go func() {
+		var first os.Signal
+		for {
+			select {
+			case sig := <-interrupts:
+				if first == nil {
+					first = sig
+				}
+				signalProcessGroup(cmd, sig)
+			case <-stop:
+				forwarded <- first
+				return
+			}
 		}
-	case results = <-doneCh:
-	}
+	}()
 
-	// do nothing if no tests were ran.
-	if strings.Index(string(results), "no Go files") != -1 ||
-		strings.Index(string(results), "no test files") != -1 {
-		return
+	// Both pipes must be drained before waiting for the command.
+	if err := <-errCh; err != nil {
+		log.Print(err)
 	}
+	results := <-doneCh
+	err = cmd.Wait()
+	close(stop)
+	return results, <-forwarded, err
+}
 
-	// Note that we should avoid using : in filename, because it is not
-	// supported on Windows file systems.
-	fname := "bench-" + time.Now().Format("2006-01-02-15-04-05") + ".txt"
-	err = ioutil.WriteFile(fname, results, 0644)
-	if err != nil {
-		// try again, maybe the user was too fast?
-		err = ioutil.WriteFile(fname, results, 0644)
-		if err != nil {
-			log.Fatal("cannot save benchmark result to your disk.")
-		}
-	}
-	log.Printf("results are saved to file: ./%s\n\n", fname)
+// formatFreq formats a CPU frequency in kHz.
+func formatFreq(khz int) string {
+	return fmt.Sprintf("%.2fGHz", float64(khz)/1e6)
+}
 
-	computeStat(results)
+// resultLabels are the labels recorded at the top of the saved
+// result file, describing the settings the benchmarks ran under.
+var resultLabels = benchfmt.Labels{}
+
+// formatLabels formats labels as "key: value" lines.
+func formatLabels(labels benchfmt.Labels) []byte {
+	var buf bytes.Buffer
+	for _, k := range labels.Keys() {
+		fmt.Fprintf(&buf, "%s: %s\n", k, labels[k])
+	}
+	return buf.Bytes()
 }
 
 var sortNames = map[string]stat.Order{
@@ -351,7 +986,10 @@ var sortNames = map[string]stat.Order{
 	"delta": stat.ByDelta,
 }
 
-func computeStat(data []byte) {
+// computeStat prints the statistics of the results in data. If
+// columns are given, the results with different values of these labels
+// are compared side by side.
+func computeStat(data []byte, columns []string) {
 	sortName := *flagSort
 	reverse := false
 	if strings.HasPrefix(sortName, "-") {
@@ -374,7 +1012,31 @@ func computeStat(data []byte) {
 		}
 		c.Order = order
 	}
-	c.AddData("", data)
+	if len(columns) == 0 {
+		c.AddData("", data)
+	} else {
+		var configs []string
+		results := make(map[string][]*benchfmt.Result)
+		br := benchfmt.NewReader(bytes.NewReader(data))
+		for br.Next() {
+			r := br.Result()
+			var config []string
+			for _, l := range columns {
+				config = append(config, l+"="+r.Labels[l])
+			}
+			k := strings.Join(config, " ")
+			if _, ok := results[k]; !ok {
+				configs = append(configs, k)
+			}
+			results[k] = append(results[k], r)
+		}
+		for _, k := range configs {
+			c.AddResults(k, results[k])
+		}
+		if len(configs) == 2 {
+			log.Print(term.Gray(fmt.Sprintf("old: %s, new: %s", configs[0], configs[1])))
+		}
+	}
 	tables := c.Tables()
 	var buf bytes.Buffer
 	stat.FormatText(&buf, tables)
@@ -0,0 +1,34 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestDropIncomplete(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{
+			"goos: linux\nBenchmarkA-8 \t100\t12 ns/op\n",
+			"goos: linux\nBenchmarkA-8 \t100\t12 ns/op\n",
+		},
+		// The last line is cut off, even if it looks complete.
+		{
+			"goos: linux\nBenchmarkA-8 \t100\t12 ns/op\nBenchmarkB-8 \t100\t12 ns/op",
+			"goos: linux\nBenchmarkA-8 \t100\t12 ns/op\n",
+		},
+		// A benchmark interrupted while running only has its name.
+		{
+			"BenchmarkA-8 \t100\t12 ns/op\nBenchmarkB-8 \t^C\nsignal: interrupt\n",
+			"BenchmarkA-8 \t100\t12 ns/op\nsignal: interrupt\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := string(dropIncomplete([]byte(tt.in))); got != tt.want {
+			t.Errorf("dropIncomplete(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.design/x/bench/internal/benchfmt"
+)
+
+// A matrixParam is a parameter of benchmark runs: an environment
+// variable, or the build tags (name "tags").
+type matrixParam struct {
+	name, value string
+}
+
+// label returns the result label of p. Labels must be lower case.
+func (p matrixParam) label() string {
+	return strings.ToLower(p.name)
+}
+
+// A matrixPoint is a combination of parameters to run benchmarks
+// with.
+type matrixPoint []matrixParam
+
+// matrix is the points of -matrix, or nil if it is not set.
+var matrix []matrixPoint
+
+// envRe matches the names of environment variables.
+var envRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseMatrix parses a -matrix specification such as
+// "GOGC=50,100;tags=a,b" and returns the points of the Cartesian
+// product of its dimensions, in order. Double quotes protect the
+// separators in values, as in GODEBUG="a=1,b=2",c=3, and are removed.
+func parseMatrix(spec string) ([]matrixPoint, error) {
+	dims, err := splitQuoted(spec, ';')
+	if err != nil {
+		return nil, err
+	}
+	points := []matrixPoint{nil}
+	seen := make(map[string]bool)
+	for _, dim := range dims {
+		dim = strings.TrimSpace(dim)
+		if dim == "" {
+			continue
+		}
+		i := strings.Index(dim, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid dimension %q, want name=value,...", dim)
+		}
+		name := dim[:i]
+		if !envRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid name %q, want an environment variable or tags", name)
+		}
+		label := matrixParam{name: name}.label()
+		if seen[label] {
+			return nil, fmt.Errorf("duplicate dimension %s", name)
+		}
+		seen[label] = true
+		values, _ := splitQuoted(dim[i+1:], ',')
+		var product []matrixPoint
+		for _, p := range points {
+			for _, v := range values {
+				q := append(p[:len(p):len(p)], matrixParam{name, strings.ReplaceAll(v, `"`, "")})
+				product = append(product, q)
+			}
+		}
+		points = product
+	}
+	if len(points[0]) == 0 {
+		return nil, fmt.Errorf("no dimensions")
+	}
+	return points, nil
+}
+
+// splitQuoted splits s at each sep outside double quotes.
+func splitQuoted(s string, sep rune) ([]string, error) {
+	var fields []string
+	start, quoted := 0, false
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	return append(fields, s[start:]), nil
+}
+
+// env returns the environment variables set by p, as name=value.
+func (p matrixPoint) env() []string {
+	var env []string
+	for _, param := range p {
+		if param.name != "tags" {
+			env = append(env, param.name+"="+param.value)
+		}
+	}
+	return env
+}
+
+// flags returns the go test flags set by p.
+func (p matrixPoint) flags() []string {
+	for _, param := range p {
+		if param.name == "tags" {
+			return []string{"-tags=" + param.value}
+		}
+	}
+	return nil
+}
+
+// labels returns the result labels of p.
+func (p matrixPoint) labels() benchfmt.Labels {
+	labels := benchfmt.Labels{}
+	for _, param := range p {
+		labels[param.label()] = param.value
+	}
+	return labels
+}
+
+func (p matrixPoint) String() string {
+	var s []string
+	for _, param := range p {
+		s = append(s, param.name+"="+param.value)
+	}
+	return strings.Join(s, " ")
+}
+
+// matrixLabels returns the result labels of the dimensions of points.
+func matrixLabels(points []matrixPoint) []string {
+	var labels []string
+	for _, param := range points[0] {
+		labels = append(labels, param.label())
+	}
+	return labels
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMatrix(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []matrixPoint
+		ok   bool
+	}{
+		{"GOGC=50,100", []matrixPoint{{{"GOGC", "50"}}, {{"GOGC", "100"}}}, true},
+		{"GOGC=50,off; tags=a,b", []matrixPoint{
+			{{"GOGC", "50"}, {"tags", "a"}},
+			{{"GOGC", "50"}, {"tags", "b"}},
+			{{"GOGC", "off"}, {"tags", "a"}},
+			{{"GOGC", "off"}, {"tags", "b"}},
+		}, true},
+		{"GOGC=50;", []matrixPoint{{{"GOGC", "50"}}}, true},
+		// Quotes protect separators and are removed.
+		{`GODEBUG="a=1,b=2",c=3`, []matrixPoint{{{"GODEBUG", "a=1,b=2"}}, {{"GODEBUG", "c=3"}}}, true},
+		{`tags="a,b";X="1;2"`, []matrixPoint{{{"tags", "a,b"}, {"X", "1;2"}}}, true},
+		{`X=""`, []matrixPoint{{{"X", ""}}}, true},
+		{`X="a,b`, nil, false},
+		{"", nil, false},
+		{"GOGC", nil, false},
+		{"1X=a", nil, false},
+		{"GOGC=50;GOGC=100", nil, false},
+		{"tags=a;TAGS=b", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := parseMatrix(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseMatrix(%q): error %v, want ok %v", tt.spec, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMatrix(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
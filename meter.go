@@ -0,0 +1,102 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// A meter measures something while each benchmark runs.
+type meter interface {
+	// start is called when a benchmark starts running.
+	start()
+	// stop is called when the benchmark finished n iterations. It
+	// returns the results to add to the benchmark, such as
+	// "1.5e-06 J/op", or none if it has no measurement.
+	stop(n int) []string
+}
+
+// An annotator adds the results of meters to the benchmark results in
+// the output of go test.
+//
+// go test prints the name of a benchmark before running it and the
+// results on the same line once it is done, so the meters are started
+// when a lone name is seen and stopped at the end of the line. The
+// meters therefore also cover the runs go test uses to choose the
+// number of iterations and the setup excluded from the timer, but
+// only the iterations of the final run are reported, so the results
+// per op overstate the cost of an operation.
+type annotator struct {
+	meters  []meter
+	line    []byte // current output line so far
+	running bool
+}
+
+// annotate processes the next chunk of go test output and returns it
+// with the meter results added to completed benchmark results.
+func (a *annotator) annotate(data []byte) []byte {
+	if len(a.meters) == 0 {
+		return data
+	}
+	var out []byte
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			a.line = append(a.line, data...)
+			out = append(out, data...)
+			a.maybeStart()
+			break
+		}
+		a.line = append(a.line, data[:i]...)
+		out = append(out, data[:i]...)
+		out = append(out, a.finish()...)
+		out = append(out, '\n')
+		a.line, a.running = a.line[:0], false
+		data = data[i+1:]
+	}
+	return out
+}
+
+// maybeStart starts the meters if the current line is the name of a
+// benchmark about to run.
+func (a *annotator) maybeStart() {
+	if a.running || !bytes.HasPrefix(a.line, []byte("Benchmark")) || !bytes.HasSuffix(a.line, []byte("\t")) {
+		return
+	}
+	if len(bytes.Fields(a.line)) != 1 {
+		return
+	}
+	a.running = true
+	for _, m := range a.meters {
+		m.start()
+	}
+}
+
+// finish stops the meters and returns their results to append to the
+// current line, or nothing if it is not a benchmark result whose start
+// was seen.
+func (a *annotator) finish() string {
+	if !a.running {
+		return ""
+	}
+	f := strings.Fields(string(a.line))
+	if len(f) < 4 {
+		return ""
+	}
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n <= 0 {
+		return ""
+	}
+	var results []string
+	for _, m := range a.meters {
+		results = append(results, m.stop(n)...)
+	}
+	if len(results) == 0 {
+		return ""
+	}
+	return "\t" + strings.Join(results, "\t")
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"golang.design/x/bench/internal/cpupower"
+	"golang.design/x/bench/internal/term"
+)
+
+// monitorInterval is how often the monitor samples the CPUs.
+const monitorInterval = time.Second
+
+// maxFreqDrop is how far below the pinned frequency a CPU may run
+// before its sample is flagged.
+const maxFreqDrop = 0.05
+
+// pinnedFreq is the frequency the CPUs were pinned to in kHz, or 0 if
+// they were not.
+var pinnedFreq int
+
+// A monitor samples the frequency, temperature and thermal throttling
+// of the benchmark's CPUs in the background while benchmarks run.
+type monitor struct {
+	sys     *cpupower.System
+	cpus    []int
+	domains []*cpupower.Domain
+	start   time.Time
+
+	stop    chan struct{}
+	done    chan struct{}
+	samples []monitorSample
+}
+
+// A monitorSample is a single sample taken by a monitor. Readings
+// that are not available on this host are zero.
+type monitorSample struct {
+	at        time.Duration // since the start of the run
+	freq      int           // highest frequency of the CPUs in kHz
+	temp      float64       // highest temperature in degrees Celsius
+	throttled bool          // throttled since the previous sample
+}
+
+// flagged reports whether the sample suggests the benchmarks did not
+// run at the expected speed.
+func (s monitorSample) flagged() bool {
+	return s.throttled || s.belowPinned()
+}
+
+// belowPinned reports whether the CPUs ran notably slower than the
+// frequency they were pinned to.
+func (s monitorSample) belowPinned() bool {
+	return pinnedFreq > 0 && s.freq > 0 && float64(s.freq) < (1-maxFreqDrop)*float64(pinnedFreq)
+}
+
+// startMonitor starts monitoring cpus, or all CPUs if nil.
+func startMonitor(cpus []int) *monitor {
+	m := &monitor{
+		sys:   cpupower.Host(),
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if cpus == nil {
+		cpus, _ = m.sys.CPUs()
+	}
+	m.cpus = cpus
+	domains, _ := m.sys.Domains()
+	for _, d := range domains {
+		if d.Covers(cpus) {
+			m.domains = append(m.domains, d)
+		}
+	}
+	go m.run()
+	return m
+}
+
+func (m *monitor) run() {
+	defer close(m.done)
+	t := time.NewTicker(monitorInterval)
+	defer t.Stop()
+	throttles := m.throttleCount()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+		s := monitorSample{at: time.Since(m.start)}
+		// Idle CPUs report a low frequency even when pinned, and
+		// benchmarks may leave most CPUs idle, so take the highest
+		// frequency, which is that of a busy CPU.
+		for _, d := range m.domains {
+			if f, err := d.CurrentFreq(); err == nil && f > s.freq {
+				s.freq = f
+			}
+		}
+		if temp, err := m.sys.MaxTemperature(); err == nil {
+			s.temp = temp
+		}
+		n := m.throttleCount()
+		s.throttled, throttles = n > throttles, n
+		m.samples = append(m.samples, s)
+	}
+}
+
+// throttleCount returns the total number of thermal throttling events
+// of the monitored CPUs.
+func (m *monitor) throttleCount() int {
+	total := 0
+	for _, c := range m.cpus {
+		if n, err := m.sys.ThrottleCount(c); err == nil {
+			total += n
+		}
+	}
+	return total
+}
+
+// Stop stops the monitor, logs a summary of its samples and records
+// it as result labels.
+func (m *monitor) Stop() {
+	close(m.stop)
+	<-m.done
+	if len(m.samples) == 0 {
+		return
+	}
+
+	var minFreq, sumFreq, nfreq, throttled int
+	var maxTemp float64
+	var flagged []monitorSample
+	for _, s := range m.samples {
+		if s.freq > 0 {
+			if nfreq == 0 || s.freq < minFreq {
+				minFreq = s.freq
+			}
+			sumFreq += s.freq
+			nfreq++
+		}
+		if s.temp > maxTemp {
+			maxTemp = s.temp
+		}
+		if s.throttled {
+			throttled++
+		}
+		if s.flagged() {
+			flagged = append(flagged, s)
+		}
+	}
+
+	summary := []string{fmt.Sprintf("%d samples", len(m.samples))}
+	if nfreq > 0 {
+		summary = append(summary, fmt.Sprintf("frequency %s min, %s mean", formatFreq(minFreq), formatFreq(sumFreq/nfreq)))
+		resultLabels["run-freq-min"] = formatFreq(minFreq)
+		resultLabels["run-freq-mean"] = formatFreq(sumFreq / nfreq)
+	}
+	if maxTemp > 0 {
+		summary = append(summary, fmt.Sprintf("temperature %.1fC max", maxTemp))
+		resultLabels["run-max-temp"] = fmt.Sprintf("%.1fC", maxTemp)
+	}
+	summary = append(summary, fmt.Sprintf("%d throttled samples", throttled))
+	resultLabels["run-throttled"] = fmt.Sprint(throttled)
+	resultLabels["run-flagged"] = fmt.Sprintf("%d/%d", len(flagged), len(m.samples))
+	log.Print(term.Gray(fmt.Sprintf("monitor: %s", strings.Join(summary, ", "))))
+
+	// Only report the first few flagged samples; a CPU that runs too
+	// hot tends to stay hot.
+	const maxReported = 5
+	for i, s := range flagged {
+		if i == maxReported {
+			log.Print(term.Orange(fmt.Sprintf("monitor: ... and %d more flagged samples", len(flagged)-maxReported)))
+			break
+		}
+		var why []string
+		if s.throttled {
+			why = append(why, "thermal throttling")
+		}
+		if s.belowPinned() {
+			why = append(why, fmt.Sprintf("frequency %s below pinned %s", formatFreq(s.freq), formatFreq(pinnedFreq)))
+		}
+		log.Print(term.Orange(fmt.Sprintf("monitor: at %v: %s", s.at.Round(time.Second), strings.Join(why, ", "))))
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// perfEventAttr is struct perf_event_attr of linux/perf_event.h, in
+// its PERF_ATTR_SIZE_VER5 layout.
+type perfEventAttr struct {
+	Type             uint32
+	Size             uint32
+	Config           uint64
+	SamplePeriod     uint64
+	SampleType       uint64
+	ReadFormat       uint64
+	Bits             uint64
+	WakeupEvents     uint32
+	BpType           uint32
+	Config1          uint64
+	Config2          uint64
+	BranchSampleType uint64
+	SampleRegsUser   uint64
+	SampleStackUser  uint32
+	ClockID          int32
+	SampleRegsIntr   uint64
+	AuxWatermark     uint32
+	SampleMaxStack   uint16
+	_                uint16
+}
+
+const (
+	perfTypeHardware = 0
+	perfTypeHWCache  = 3
+
+	perfCountHWCPUCycles    = 0
+	perfCountHWInstructions = 1
+	perfCountHWCacheMisses  = 3
+	perfCountHWBranchMisses = 5
+
+	// The last level cache, read accesses.
+	perfCountHWCacheLLReadAccess = 2 | 0<<8 | 0<<16
+
+	perfFormatTotalTimeEnabled = 1 << 0
+	perfFormatTotalTimeRunning = 1 << 1
+
+	perfBitDisabled      = 1 << 0
+	perfBitInherit       = 1 << 1
+	perfBitExcludeKernel = 1 << 5
+	perfBitExcludeHV     = 1 << 6
+	perfBitEnableOnExec  = 1 << 12
+)
+
+// perfEvents are the counted events and the units they are reported
+// in.
+var perfEvents = []struct {
+	typ    uint32
+	config uint64
+	unit   string
+}{
+	{perfTypeHardware, perfCountHWInstructions, "instructions/op"},
+	{perfTypeHardware, perfCountHWCPUCycles, "cycles/op"},
+	{perfTypeHardware, perfCountHWBranchMisses, "branch-misses/op"},
+	{perfTypeHardware, perfCountHWCacheMisses, "cache-misses/op"},
+	{perfTypeHWCache, perfCountHWCacheLLReadAccess, "LLC-loads/op"},
+}
+
+// A perfMeter counts hardware events of the benchmarks with
+// perf_event_open, per operation, along with the instructions per
+// cycle (IPC). Only user space events are counted, which needs no
+// privileges on most systems.
+type perfMeter struct {
+	fds      []int   // by perfEvents index, -1 if unsupported
+	readings []int64 // at the start of the current benchmark
+}
+
+// newPerfMeter opens the counters on the current thread, so that the
+// processes it starts inherit them, enabled from their exec. It locks
+// the calling goroutine to its thread until detach is called, which
+// must be done right after starting the command.
+func newPerfMeter() (*perfMeter, error) {
+	runtime.LockOSThread()
+	m := &perfMeter{}
+	supported := 0
+	for _, ev := range perfEvents {
+		attr := perfEventAttr{
+			Type:       ev.typ,
+			Config:     ev.config,
+			ReadFormat: perfFormatTotalTimeEnabled | perfFormatTotalTimeRunning,
+			Bits:       perfBitDisabled | perfBitInherit | perfBitExcludeKernel | perfBitExcludeHV | perfBitEnableOnExec,
+		}
+		attr.Size = uint32(unsafe.Sizeof(attr))
+		fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN,
+			uintptr(unsafe.Pointer(&attr)), 0, ^uintptr(0), ^uintptr(0), 0, 0)
+		if errno != 0 {
+			m.fds = append(m.fds, -1)
+			continue
+		}
+		syscall.CloseOnExec(int(fd))
+		m.fds = append(m.fds, int(fd))
+		supported++
+	}
+	if supported == 0 {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("perf_event_open: no hardware counters available")
+	}
+	return m, nil
+}
+
+// detach unlocks the goroutine from the thread the counters were
+// opened on.
+func (m *perfMeter) detach() {
+	runtime.UnlockOSThread()
+}
+
+// close closes the counters.
+func (m *perfMeter) close() {
+	for _, fd := range m.fds {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
+
+// read returns the current counts, scaled up for the time the
+// counters were not scheduled on the PMU, or -1 for unsupported
+// events.
+func (m *perfMeter) read() []int64 {
+	counts := make([]int64, len(m.fds))
+	var buf [24]byte
+	for i, fd := range m.fds {
+		counts[i] = -1
+		if fd < 0 {
+			continue
+		}
+		if n, err := syscall.Read(fd, buf[:]); err != nil || n != len(buf) {
+			continue
+		}
+		value := binary.LittleEndian.Uint64(buf[0:])
+		enabled := binary.LittleEndian.Uint64(buf[8:])
+		running := binary.LittleEndian.Uint64(buf[16:])
+		if running > 0 && running < enabled {
+			value = uint64(float64(value) * float64(enabled) / float64(running))
+		}
+		counts[i] = int64(value)
+	}
+	return counts
+}
+
+func (m *perfMeter) start() {
+	m.readings = m.read()
+}
+
+func (m *perfMeter) stop(n int) []string {
+	if m.readings == nil {
+		return nil
+	}
+	end := m.read()
+	var results []string
+	delta := make([]int64, len(end))
+	for i := range end {
+		delta[i] = -1
+		if end[i] < 0 || m.readings[i] < 0 {
+			continue
+		}
+		delta[i] = end[i] - m.readings[i]
+		results = append(results, strconv.FormatFloat(float64(delta[i])/float64(n), 'f', 2, 64)+" "+perfEvents[i].unit)
+	}
+	if instructions, cycles := delta[0], delta[1]; instructions >= 0 && cycles > 0 {
+		results = append(results, strconv.FormatFloat(float64(instructions)/float64(cycles), 'f', 3, 64)+" IPC")
+	}
+	return results
+}
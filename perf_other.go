@@ -0,0 +1,21 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+type perfMeter struct{}
+
+func newPerfMeter() (*perfMeter, error) {
+	return nil, errors.New("hardware performance counters are only supported on Linux")
+}
+
+func (m *perfMeter) detach()             {}
+func (m *perfMeter) close()              {}
+func (m *perfMeter) start()              {}
+func (m *perfMeter) stop(n int) []string { return nil }
@@ -0,0 +1,213 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.design/x/bench/internal/cpupower"
+	"golang.design/x/bench/internal/term"
+)
+
+// Limits above which the machine is considered too noisy to run
+// benchmarks.
+const (
+	maxLoadPerCPU     = 0.2  // 1-minute load average per CPU
+	maxCPUBusy        = 10.0 // percent of CPU time
+	maxMemoryPressure = 1.0  // percent of time stalled on memory
+	maxTemperature    = 80.0 // degrees Celsius
+)
+
+// cpuSampleTime is how long CPU utilization is sampled for.
+const cpuSampleTime = 500 * time.Millisecond
+
+// loadSettleTime is how long to wait at most for the load average to
+// decay, such as after the previous lock holder finished.
+const loadSettleTime = 2 * time.Minute
+
+// A noiseReading is a single measurement of machine noise.
+type noiseReading struct {
+	label string // result label
+	desc  string
+	value float64
+	limit float64
+	unit  string
+}
+
+func (r noiseReading) String() string {
+	return fmt.Sprintf("%.2f%s", r.value, r.unit)
+}
+
+// preflight samples the machine noise before running benchmarks and
+// records the readings as result labels. It warns about readings
+// above their limits, and refuses to run with -require-quiet.
+// Readings that are not available on this host are skipped.
+func preflight() {
+	var readings []noiseReading
+
+	// Other lock holders run on other CPUs with -cpus, so the load
+	// average, which covers all CPUs, is meaningless then.
+	if benchCPUs == nil {
+		limit := maxLoadPerCPU * float64(runtime.NumCPU())
+		if load, err := settleLoadAvg(limit); err == nil {
+			readings = append(readings, noiseReading{"loadavg", "load average", load, limit, ""})
+		}
+	}
+	if busy, err := sampleCPUBusy(benchCPUs, cpuSampleTime); err == nil {
+		readings = append(readings, noiseReading{"cpu-busy", "CPU busy", busy, maxCPUBusy, "%"})
+	}
+	if pressure, err := readMemoryPressure(); err == nil {
+		readings = append(readings, noiseReading{"mem-pressure", "memory pressure", pressure, maxMemoryPressure, "%"})
+	}
+	if temp, err := cpupower.Host().MaxTemperature(); err == nil {
+		readings = append(readings, noiseReading{"max-temp", "temperature", temp, maxTemperature, "C"})
+	}
+	if len(readings) == 0 {
+		return
+	}
+
+	noisy := false
+	var summary []string
+	for _, r := range readings {
+		resultLabels[r.label] = r.String()
+		summary = append(summary, fmt.Sprintf("%s %s", r.desc, r))
+		if r.value > r.limit {
+			noisy = true
+			log.Print(term.Orange(fmt.Sprintf("machine is not quiet: %s is %s, above %.2f%s", r.desc, r, r.limit, r.unit)))
+		}
+	}
+	if noisy && *flagRequireQuiet {
+		log.Fatal("refusing to run benchmarks on a busy machine (-require-quiet)")
+	}
+	if !noisy {
+		log.Print(term.Gray(fmt.Sprintf("machine is quiet: %s", strings.Join(summary, ", "))))
+	}
+}
+
+// readLoadAvg returns the 1-minute load average.
+func readLoadAvg() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("/proc/loadavg: unexpected format")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// settleLoadAvg returns the 1-minute load average once it is below
+// limit or stops decreasing. The load average decays slowly, so right
+// after the lock is acquired it still counts the previous holder.
+func settleLoadAvg(limit float64) (float64, error) {
+	load, err := readLoadAvg()
+	if err != nil || load <= limit {
+		return load, err
+	}
+	log.Print(term.Gray(fmt.Sprintf("waiting for the load average %.2f to settle...", load)))
+	deadline := time.Now().Add(loadSettleTime)
+	for time.Now().Before(deadline) {
+		// The kernel updates the load average a little less often
+		// than every 5 seconds.
+		time.Sleep(6 * time.Second)
+		next, err := readLoadAvg()
+		if err != nil {
+			return 0, err
+		}
+		if next >= load {
+			break
+		}
+		load = next
+		if load <= limit {
+			break
+		}
+	}
+	return load, nil
+}
+
+// sampleCPUBusy returns the percentage of time cpus, or all CPUs if
+// nil, were busy during d.
+func sampleCPUBusy(cpus []int, d time.Duration) (float64, error) {
+	busy0, total0, err := readCPUTimes(cpus)
+	if err != nil {
+		return 0, err
+	}
+	time.Sleep(d)
+	busy1, total1, err := readCPUTimes(cpus)
+	if err != nil {
+		return 0, err
+	}
+	if total1 <= total0 {
+		return 0, fmt.Errorf("/proc/stat: no CPU time elapsed")
+	}
+	return 100 * float64(busy1-busy0) / float64(total1-total0), nil
+}
+
+// readCPUTimes returns the busy and total time of cpus, or of all
+// CPUs if nil, from /proc/stat in clock ticks.
+func readCPUTimes(cpus []int) (busy, total uint64, err error) {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return 0, 0, err
+	}
+	want := map[string]bool{"cpu": cpus == nil}
+	for _, c := range cpus {
+		want[fmt.Sprintf("cpu%d", c)] = true
+	}
+	found := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !want[fields[0]] {
+			continue
+		}
+		found = true
+		// user nice system idle iowait irq softirq steal ...
+		for i, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("/proc/stat: %v", err)
+			}
+			if i >= 8 {
+				// guest time is already included in user time.
+				break
+			}
+			total += v
+			if i != 3 && i != 4 {
+				busy += v
+			}
+		}
+	}
+	if !found {
+		return 0, 0, fmt.Errorf("/proc/stat: no CPU times")
+	}
+	return busy, total, nil
+}
+
+// readMemoryPressure returns the percentage of the last 10 seconds
+// in which some tasks were stalled on memory, which includes time
+// spent swapping.
+func readMemoryPressure() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/pressure/memory")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		if v := strings.TrimPrefix(fields[1], "avg10="); v != fields[1] {
+			return strconv.ParseFloat(v, 64)
+		}
+	}
+	return 0, fmt.Errorf("/proc/pressure/memory: unexpected format")
+}
@@ -0,0 +1,344 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.design/x/bench/internal/benchfmt"
+	"golang.design/x/bench/internal/stat"
+	"golang.design/x/bench/internal/term"
+)
+
+// dirtySuffix marks a commit label of a working tree with uncommitted
+// changes.
+const dirtySuffix = "-dirty"
+
+// gitCommit returns the commit checked out in the working directory,
+// with dirtySuffix if tracked files have uncommitted changes, or "" if
+// the working directory is not in a git repository.
+func gitCommit() string {
+	out, err := exec.Command("git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(out))
+	// Result and profile files are usually untracked, so ignore
+	// untracked files.
+	out, err = exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output()
+	if err == nil && len(bytes.TrimSpace(out)) > 0 {
+		commit += dirtySuffix
+	}
+	return commit
+}
+
+// A regression is a benchmark that got worse in a comparison.
+type regression struct {
+	pkg   string // import path, or "" if unknown
+	name  string // without the Benchmark prefix and GOMAXPROCS suffix
+	procs string // GOMAXPROCS the benchmark ran with, or ""
+}
+
+// procsRe matches the GOMAXPROCS suffix go test adds to benchmark
+// names unless GOMAXPROCS is 1. A sub-benchmark whose own name ends in
+// a number run with GOMAXPROCS 1 is indistinguishable, so such
+// benchmarks are not found again.
+var procsRe = regexp.MustCompile(`-(\d+)$`)
+
+// pattern returns the -bench pattern that matches only r.
+func (r regression) pattern() string {
+	parts := strings.Split("Benchmark"+r.name, "/")
+	for i, p := range parts {
+		parts[i] = "^" + regexp.QuoteMeta(p) + "$"
+	}
+	return strings.Join(parts, "/")
+}
+
+// fileName returns a name for files about r, safe on all file systems.
+func (r regression) fileName() string {
+	name := r.name
+	if r.procs != "" {
+		// Keep the profiles of each GOMAXPROCS apart.
+		name += "-" + r.procs
+	}
+	if r.pkg != "" {
+		name = path.Base(r.pkg) + "." + name
+	}
+	return strings.Map(func(c rune) rune {
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("._-", c) {
+			return c
+		}
+		return '_'
+	}, name)
+}
+
+func (r regression) String() string {
+	s := r.name
+	if r.procs != "" {
+		s += "-" + r.procs
+	}
+	if r.pkg != "" {
+		s = r.pkg + "." + s
+	}
+	return s
+}
+
+// findRegressions returns the benchmarks that got worse in any of the
+// tables comparing the result files.
+func findRegressions(tables []*stat.Table, files []string) ([]regression, error) {
+	// The tables lose the packages of benchmarks unless they are
+	// split by them, so look them up in the files.
+	pkgs := make(map[string][]string)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		br := benchfmt.NewReader(f)
+		for br.Next() {
+			r := br.Result()
+			f := strings.Fields(r.Content)
+			if len(f) == 0 {
+				continue
+			}
+			name := strings.TrimPrefix(f[0], "Benchmark")
+			if pkg := r.Labels["pkg"]; !contains(pkgs[name], pkg) {
+				pkgs[name] = append(pkgs[name], pkg)
+			}
+		}
+		f.Close()
+		if err := br.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var regs []regression
+	seen := make(map[regression]bool)
+	for _, t := range tables {
+		for _, row := range t.Rows {
+			if row.Change != -1 {
+				continue
+			}
+			for _, pkg := range pkgs[row.Benchmark] {
+				r := regression{pkg: pkg, name: row.Benchmark}
+				if m := procsRe.FindStringSubmatch(r.name); m != nil {
+					r.name, r.procs = strings.TrimSuffix(r.name, m[0]), m[1]
+				}
+				if !seen[r] {
+					seen[r] = true
+					regs = append(regs, r)
+				}
+			}
+		}
+	}
+	return regs, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// A profileSide is the source code one side of a comparison ran on.
+type profileSide struct {
+	file     string // result file
+	commit   string // commit label of file
+	dir      string // directory to run go test in
+	worktree string // temporary git worktree, if any
+}
+
+// checkout prepares the source code the results in s.file ran on. The
+// working tree is used if it is at the same commit, otherwise the
+// commit is checked out in a temporary git worktree.
+func (s *profileSide) checkout(current string) error {
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := benchfmt.NewReader(f)
+	if br.Next() {
+		s.commit = br.Result().Labels["commit"]
+	}
+	if err := br.Err(); err != nil {
+		return err
+	}
+	switch {
+	case s.commit == "":
+		return fmt.Errorf("%s: no commit label, cannot rerun its benchmarks", s.file)
+	case s.commit == current:
+		if strings.HasSuffix(s.commit, dirtySuffix) {
+			log.Print(term.Orange(fmt.Sprintf("%s ran on uncommitted changes, assuming they are still in the working tree", s.file)))
+		}
+		s.dir = "."
+		return nil
+	case strings.HasSuffix(s.commit, dirtySuffix):
+		return fmt.Errorf("%s: ran on uncommitted changes to %s, cannot rerun its benchmarks", s.file, strings.TrimSuffix(s.commit, dirtySuffix))
+	}
+
+	// Run in the same directory of the worktree, so that the same
+	// package is benchmarked.
+	prefix, err := exec.Command("git", "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		return fmt.Errorf("git rev-parse: %v", err)
+	}
+	s.worktree, err = ioutil.TempDir("", "bench-worktree-")
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("git", "worktree", "add", "--detach", s.worktree, s.commit).CombinedOutput()
+	if err != nil {
+		os.RemoveAll(s.worktree)
+		s.worktree = ""
+		return fmt.Errorf("git worktree add %s: %v\n%s", s.commit, err, out)
+	}
+	s.dir = filepath.Join(s.worktree, strings.TrimSpace(string(prefix)))
+	return nil
+}
+
+// cleanup removes the temporary worktree of s, if any.
+func (s *profileSide) cleanup() {
+	if s.worktree == "" {
+		return
+	}
+	if out, err := exec.Command("git", "worktree", "remove", "--force", s.worktree).CombinedOutput(); err != nil {
+		log.Print(term.Orange(fmt.Sprintf("failed to remove worktree %s: %v\n%s", s.worktree, err, out)))
+	}
+}
+
+// profileFile returns the name of the profile of kind ("cpu" or
+// "mem") of r on side s, next to its result file.
+func (s *profileSide) profileFile(r regression, kind string) string {
+	base := strings.TrimSuffix(s.file, filepath.Ext(s.file))
+	return fmt.Sprintf("%s.%s.%s.pprof", base, r.fileName(), kind)
+}
+
+// profileRegressions reruns the benchmarks that got worse between the
+// result files old and new once on each side with CPU and memory
+// profiling, and saves the profiles and a summary of their difference
+// next to the result files.
+func profileRegressions(tables []*stat.Table, old, new string) {
+	regs, err := findRegressions(tables, []string{old, new})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(regs) == 0 {
+		log.Print(term.Gray("no regressions to profile"))
+		return
+	}
+
+	current := gitCommit()
+	sides := []*profileSide{{file: old}, {file: new}}
+	for _, s := range sides {
+		defer s.cleanup()
+		if err := s.checkout(current); err != nil {
+			log.Print(err)
+			return
+		}
+	}
+	if sides[0].commit == sides[1].commit {
+		log.Printf("%s and %s ran on the same commit %s, cannot profile the difference", old, new, sides[0].commit)
+		return
+	}
+
+	bindir, err := ioutil.TempDir("", "bench-profile-")
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	defer os.RemoveAll(bindir)
+
+	c := acquireLock(fmt.Sprintf("profile %d regressions of %s", len(regs), new))
+	if c != nil {
+		defer c.Close()
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].String() < regs[j].String() })
+	for _, r := range regs {
+		log.Print(term.Gray(fmt.Sprintf("profile %s...", r)))
+		ok := true
+		for i, s := range sides {
+			args := []string{
+				"go", "test",
+				"-run=^$",
+				"-bench=" + r.pattern(),
+				"-count=1",
+				"-cpuprofile=" + abs(s.profileFile(r, "cpu")),
+				"-memprofile=" + abs(s.profileFile(r, "mem")),
+				// Profiling leaves the test binary behind.
+				"-o=" + filepath.Join(bindir, fmt.Sprintf("side%d.test", i)),
+			}
+			if *flagTime != "" {
+				args = append(args, "-benchtime="+*flagTime)
+			}
+			if r.procs != "" {
+				args = append(args, "-cpu="+r.procs)
+			}
+			if r.pkg != "" {
+				args = append(args, r.pkg)
+			}
+			args = append(args, testFlags...)
+			log.Printf("%s: %s", s.commit, strings.Join(args, " "))
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Dir, cmd.Stdout, cmd.Stderr = s.dir, os.Stdout, os.Stderr
+			if err := startPinned(c, cmd); err != nil {
+				log.Print(err)
+				return
+			}
+			if err := cmd.Wait(); err != nil {
+				log.Print(term.Orange(fmt.Sprintf("failed to profile %s on %s: %v", r, s.commit, err)))
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		var summary bytes.Buffer
+		for _, kind := range []string{"cpu", "mem"} {
+			args := []string{"tool", "pprof", "-top", "-nodecount=20"}
+			if kind == "mem" {
+				args = append(args, "-sample_index=alloc_space")
+			}
+			args = append(args, "-diff_base="+sides[0].profileFile(r, kind), sides[1].profileFile(r, kind))
+			out, err := exec.Command("go", args...).Output()
+			if err != nil {
+				log.Print(term.Orange(fmt.Sprintf("failed to compare %s profiles of %s: %v", kind, r, err)))
+				continue
+			}
+			fmt.Fprintf(&summary, "# %s: go %s\n%s\n", r, strings.Join(args, " "), out)
+		}
+		fname := strings.TrimSuffix(new, filepath.Ext(new)) + "." + r.fileName() + ".diff.txt"
+		if err := ioutil.WriteFile(fname, summary.Bytes(), 0644); err != nil {
+			log.Print(err)
+		} else {
+			log.Printf("profiles of %s are saved next to %s, differences in %s\n\n", r, new, fname)
+		}
+		os.Stdout.Write(summary.Bytes())
+	}
+}
+
+// abs returns the absolute path of file, since go test resolves
+// profile paths relative to the package directory.
+func abs(file string) string {
+	if p, err := filepath.Abs(file); err == nil {
+		return p
+	}
+	return file
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.design/x/bench/internal/benchfmt"
+	"golang.design/x/bench/internal/stat"
+	"golang.design/x/bench/internal/term"
+)
+
+// A progress displays the output of go test as it arrives.
+//
+// In compact mode, used on terminals, the result lines of each
+// benchmark are replaced by a status line with the number of runs,
+// the running mean and the estimated time to finish its -count runs,
+// and a summary line once the next benchmark starts. All other output
+// is shown as is. Otherwise the output is copied unchanged.
+type progress struct {
+	mu      sync.Mutex
+	parse   *sync.Cond // signaled when results are parsed
+	compact bool
+	count   int    // expected runs of each benchmark
+	line    []byte // incomplete output line
+	status  string // status line currently shown
+
+	// Other output waits until the results before it are parsed, so
+	// that it is shown in order.
+	seen    int  // result lines written
+	parsed  int  // result lines parsed
+	watched bool // no more results will be parsed
+
+	// The current benchmark.
+	name  string
+	unit  string // of the first metric
+	sum   float64
+	runs  int
+	start time.Time // of its first run
+	last  time.Time // of its latest result
+}
+
+// newProgress returns a progress for benchmarks expected to run count
+// times each.
+func newProgress(count int) *progress {
+	p := &progress{
+		compact: isTerminal(os.Stdout) && !*flagVerbose,
+		count:   count,
+		last:    time.Now(),
+	}
+	p.parse = sync.NewCond(&p.mu)
+	return p
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// watch parses the go test output read from r as it arrives and
+// updates the status line for each result. It returns once r is
+// exhausted.
+func (p *progress) watch(r io.Reader) {
+	br := benchfmt.NewReader(r)
+	for br.Next() {
+		p.result(br.Result())
+	}
+	p.mu.Lock()
+	p.watched = true
+	p.parse.Broadcast()
+	p.mu.Unlock()
+	// Keep draining, so that writers are not blocked.
+	io.Copy(ioutil.Discard, r)
+}
+
+// stdout shows a chunk of the standard output of go test, which must
+// have been written to the reader passed to watch.
+func (p *progress) stdout(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.compact {
+		os.Stdout.Write(data)
+		return
+	}
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			p.line = append(p.line, data...)
+			return
+		}
+		line := append(p.line, data[:i+1]...)
+		p.line, data = p.line[:0], data[i+1:]
+		if isResultLine(line) {
+			// Shown by the status line.
+			p.seen++
+			continue
+		}
+		for p.parsed < p.seen && !p.watched {
+			p.parse.Wait()
+		}
+		p.clear()
+		if isFailureLine(line) {
+			line = []byte(term.Red(string(bytes.TrimSuffix(line, []byte("\n")))) + "\n")
+		}
+		os.Stdout.Write(line)
+		p.draw()
+		if p.name == "" {
+			// Not benchmarking yet, maybe still building.
+			p.last = time.Now()
+		}
+	}
+}
+
+// stderr shows a chunk of the standard error of go test.
+func (p *progress) stderr(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	os.Stderr.Write(data)
+	p.draw()
+}
+
+// isResultLine reports whether line is a benchmark result, or a
+// benchmark result with its name printed before it ran.
+func isResultLine(line []byte) bool {
+	f := strings.Fields(string(line))
+	if len(f) < 4 || !strings.HasPrefix(f[0], "Benchmark") {
+		return false
+	}
+	_, err := strconv.Atoi(f[1])
+	return err == nil
+}
+
+// hasResults reports whether the go test output out includes any
+// benchmark results.
+func hasResults(out []byte) bool {
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if isResultLine(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFailureLine reports whether line of go test output reports a
+// failed benchmark or package, or a crash.
+func isFailureLine(line []byte) bool {
+	for _, prefix := range []string{"--- FAIL: ", "FAIL", "panic: ", "fatal error: "} {
+		if bytes.HasPrefix(line, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// failureLines returns the lines of the go test output out that report
+// failed benchmarks, packages that failed to build and crashes, each
+// prefixed with its package if known.
+func failureLines(out []byte) []string {
+	var pkg string // from the pkg label, printed with the first result
+	var lines, pending []string
+	flush := func(pkg string) {
+		for _, line := range pending {
+			if pkg != "" {
+				line = pkg + ": " + line
+			}
+			lines = append(lines, line)
+		}
+		pending = nil
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "pkg: "):
+			pkg = strings.TrimPrefix(line, "pkg: ")
+		case strings.HasPrefix(line, "--- FAIL: "),
+			strings.HasPrefix(line, "panic: "),
+			strings.HasPrefix(line, "fatal error: "):
+			pending = append(pending, strings.TrimSpace(line))
+		case strings.HasPrefix(line, "FAIL\t") && len(f) >= 2:
+			// The last line of a failed package, such as
+			// "FAIL\tpkg\t0.01s" or "FAIL\tpkg [build failed]".
+			flush(f[1])
+			if strings.HasSuffix(line, " failed]") {
+				lines = append(lines, strings.Join(f, " "))
+			}
+		}
+	}
+	flush(pkg)
+	return lines
+}
+
+// result records the benchmark result r.
+func (p *progress) result(r *benchfmt.Result) {
+	f := strings.Fields(r.Content)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if isResultLine([]byte(r.Content)) {
+		p.parsed++
+		p.parse.Broadcast()
+	}
+	if len(f) < 4 {
+		return
+	}
+	val, err := strconv.ParseFloat(f[2], 64)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if f[0] != p.name {
+		p.finish()
+		// The first run started when the previous result, if any,
+		// was printed.
+		p.name, p.unit, p.sum, p.runs, p.start = f[0], f[3], 0, 0, p.last
+	}
+	p.sum += val
+	p.runs++
+	p.last = now
+	p.clear()
+	if p.runs == p.count {
+		p.finish()
+		return
+	}
+	p.draw()
+}
+
+// done finishes the display of the current benchmark.
+func (p *progress) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.finish()
+	if len(p.line) > 0 && p.compact {
+		os.Stdout.Write(p.line)
+		p.line = p.line[:0]
+	}
+}
+
+// finish prints the summary of the current benchmark in compact mode.
+func (p *progress) finish() {
+	if !p.compact || p.name == "" {
+		return
+	}
+	p.clear()
+	fmt.Fprintf(os.Stdout, "%s\t%s\n", p.name, p.summary())
+	p.name = ""
+}
+
+// summary describes the runs of the current benchmark so far.
+func (p *progress) summary() string {
+	mean := p.sum / float64(p.runs)
+	var m string
+	if p.unit == "ns/op" {
+		m = stat.NewScaler(mean, p.unit)(mean) + "/op"
+	} else {
+		m = strconv.FormatFloat(mean, 'g', 4, 64) + " " + p.unit
+	}
+	runs := strconv.Itoa(p.runs)
+	if p.runs <= p.count {
+		runs += "/" + strconv.Itoa(p.count)
+	}
+	return fmt.Sprintf("%s runs\tmean %s", runs, m)
+}
+
+// draw shows the status line of the current benchmark, with the time
+// left estimated from the runs completed so far.
+func (p *progress) draw() {
+	if !p.compact || p.name == "" {
+		return
+	}
+	p.status = p.name + "\t" + p.summary()
+	if left := p.count - p.runs; left > 0 {
+		perRun := p.last.Sub(p.start) / time.Duration(p.runs)
+		p.status += fmt.Sprintf("\tETA %v", (perRun * time.Duration(left)).Round(time.Second))
+	}
+	fmt.Fprint(os.Stdout, term.Gray(p.status))
+}
+
+// clear removes the status line.
+func (p *progress) clear() {
+	if p.status == "" {
+		return
+	}
+	fmt.Fprint(os.Stdout, "\r\033[K")
+	p.status = ""
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsResultLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"BenchmarkFoo-8   	 1000000	      1052 ns/op\n", true},
+		{"BenchmarkFoo-8   	 1000000	      1052 ns/op	      16 B/op	       1 allocs/op", true},
+		// With -v, the name is printed before the benchmark runs.
+		{"BenchmarkFoo/sub=1-8   	     100	  12 ns/op", true},
+		{"BenchmarkFoo-8", false},
+		{"BenchmarkFoo-8   	--- FAIL: BenchmarkFoo-8", false},
+		{"BenchmarkFoo-8   	    1000", false},
+		{"goos: linux", false},
+		{"PASS", false},
+		{"ok  	example.com/pr	1.234s", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isResultLine([]byte(tt.line)); got != tt.want {
+			t.Errorf("isResultLine(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFailureLines(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []string
+	}{
+		{
+			name: "pass",
+			out:  "goos: linux\npkg: example.com/pr\nBenchmarkA-8 \t100\t12 ns/op\nPASS\nok  \texample.com/pr\t1.2s\n",
+			want: nil,
+		},
+		{
+			name: "failed benchmark",
+			out: "pkg: example.com/pr/a\nBenchmarkA-8 \t100\t12 ns/op\n--- FAIL: BenchmarkB-8\n    a_test.go:10: oops\n" +
+				"FAIL\nexit status 1\nFAIL\texample.com/pr/a\t0.5s\n" +
+				"pkg: example.com/pr/b\nBenchmarkC-8 \t100\t12 ns/op\nPASS\nok  \texample.com/pr/b\t0.5s\n",
+			want: []string{"example.com/pr/a: --- FAIL: BenchmarkB-8"},
+		},
+		{
+			// A crash before any results leaves no pkg label.
+			name: "panic",
+			out:  "panic: boom\n\ngoroutine 1 [running]:\nmain.f()\nFAIL\texample.com/pr\t0.01s\n",
+			want: []string{"example.com/pr: panic: boom"},
+		},
+		{
+			name: "build failed",
+			out:  "# example.com/pr\n./a.go:3:1: syntax error\nFAIL\texample.com/pr [build failed]\nFAIL\n",
+			want: []string{"FAIL example.com/pr [build failed]"},
+		},
+		{
+			// Without the package summary, such as when go test
+			// is killed.
+			name: "fatal error",
+			out:  "pkg: example.com/pr\nfatal error: out of memory\n",
+			want: []string{"example.com/pr: fatal error: out of memory"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := failureLines([]byte(tt.out)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("failureLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// projectFile is the name of the project configuration file, searched
+// for in the working directory and its parents.
+const projectFile = ".bench.json"
+
+// projectConfig is the configuration of a project. It sets defaults
+// for the flags of bench, by flag name without the dash, such as
+//
+//	{
+//		"flags": {"count": 20, "cpufreq": 80, "split": "pkg,goos"},
+//		"profiles": {
+//			"ci": {"count": 5, "require-quiet": true}
+//		},
+//		"packages": {
+//			"example.com/hot/...": {"name": "^BenchmarkHot", "time": "2s"}
+//		}
+//	}
+//
+// A profile selected with -profile overrides the flags, and packages
+// override the go test flags of the packages matching their import
+// path patterns. Flags given on the command line take precedence.
+type projectConfig struct {
+	file    string
+	cmdLine map[string]bool // flags given on the command line
+
+	Flags    map[string]interface{}            `json:"flags"`
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+	Packages map[string]map[string]interface{} `json:"packages"`
+}
+
+// project is the configuration of the current project, or nil if it
+// has none.
+var project *projectConfig
+
+// projectOnlyFlags are the flags that cannot be set by a project.
+var projectOnlyFlags = map[string]bool{
+	"daemon":  true,
+	"list":    true,
+	"lock":    true,
+	"config":  true,
+	"profile": true,
+}
+
+// packageFlags are the flags that can be set per package.
+var packageFlags = map[string]bool{
+	"v":        true,
+	"name":     true,
+	"count":    true,
+	"time":     true,
+	"cpuprocs": true,
+}
+
+// findProjectConfig returns the project configuration file in dir or
+// the closest of its parents, or "" if there is none.
+func findProjectConfig(dir string) string {
+	for {
+		file := filepath.Join(dir, projectFile)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProjectConfig reads the project configuration from file. It
+// must be called after the command line is parsed.
+func loadProjectConfig(file string) (*projectConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &projectConfig{file: file, cmdLine: make(map[string]bool)}
+	flag.Visit(func(f *flag.Flag) { cfg.cmdLine[f.Name] = true })
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	if err := cfg.check(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// check validates the flags set by cfg.
+func (cfg *projectConfig) check() error {
+	checkFlags := func(where string, flags map[string]interface{}, allowed map[string]bool) error {
+		for name, v := range flags {
+			if flag.Lookup(name) == nil || projectOnlyFlags[name] || allowed != nil && !allowed[name] {
+				return fmt.Errorf("%s: flag -%s cannot be set", where, name)
+			}
+			switch v.(type) {
+			case string, float64, bool:
+			default:
+				return fmt.Errorf("%s: invalid value for -%s: %v", where, name, v)
+			}
+		}
+		return nil
+	}
+	if err := checkFlags("flags", cfg.Flags, nil); err != nil {
+		return err
+	}
+	for name, flags := range cfg.Profiles {
+		if err := checkFlags(fmt.Sprintf("profile %q", name), flags, nil); err != nil {
+			return err
+		}
+	}
+	for pattern, flags := range cfg.Packages {
+		if err := checkFlags(fmt.Sprintf("package %q", pattern), flags, packageFlags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets the flags not given on the command line to the values of
+// cfg and of its named profile, if not "".
+func (cfg *projectConfig) apply(profile string) error {
+	flags := cfg.Flags
+	if profile != "" {
+		p, ok := cfg.Profiles[profile]
+		if !ok {
+			return fmt.Errorf("%s: no profile %q", cfg.file, profile)
+		}
+		flags = merge(flags, p)
+	}
+	for name, v := range cfg.withoutCommandLine(flags) {
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("%s: invalid value %q for -%s: %v", cfg.file, v, name, err)
+		}
+	}
+	return nil
+}
+
+// packageFlags returns the go test flags of bench set for the package
+// pkg, by flag name. Patterns ending in "/..." match the packages
+// below them, and more specific patterns take precedence.
+func (cfg *projectConfig) packageFlags(pkg string) map[string]string {
+	var patterns []string
+	for pattern := range cfg.Packages {
+		if matchPackage(pattern, pkg) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) < len(patterns[j]) })
+	var flags map[string]interface{}
+	for _, pattern := range patterns {
+		flags = merge(flags, cfg.Packages[pattern])
+	}
+	return cfg.withoutCommandLine(flags)
+}
+
+// matchPackage reports whether the import path pattern matches pkg.
+func matchPackage(pattern, pkg string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+		return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	return pattern == pkg
+}
+
+// merge returns the flags of a overridden by b.
+func merge(a, b map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// withoutCommandLine returns flags formatted as flag values, without
+// those given on the command line.
+func (cfg *projectConfig) withoutCommandLine(flags map[string]interface{}) map[string]string {
+	values := make(map[string]string)
+	for name, v := range flags {
+		if !cfg.cmdLine[name] {
+			values[name] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestMatchPackage(t *testing.T) {
+	tests := []struct {
+		pattern, pkg string
+		want         bool
+	}{
+		{"example.com/hot", "example.com/hot", true},
+		{"example.com/hot", "example.com/hot/a", false},
+		{"example.com/hot/...", "example.com/hot", true},
+		{"example.com/hot/...", "example.com/hot/a/b", true},
+		{"example.com/hot/...", "example.com/hotter", false},
+		{"example.com/hot/...", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := matchPackage(tt.pattern, tt.pkg); got != tt.want {
+			t.Errorf("matchPackage(%q, %q) = %v, want %v", tt.pattern, tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestPackageFlags(t *testing.T) {
+	cfg := &projectConfig{
+		cmdLine: map[string]bool{"count": true},
+		Packages: map[string]map[string]interface{}{
+			"example.com/...":       {"time": "1s", "count": 5.0},
+			"example.com/hot/...":   {"time": "2s", "name": "^BenchmarkHot"},
+			"example.com/hot/inner": {"v": true},
+		},
+	}
+	tests := []struct {
+		pkg  string
+		want map[string]string
+	}{
+		{"other.com/a", map[string]string{}},
+		{"example.com/a", map[string]string{"time": "1s"}},
+		// More specific patterns take precedence.
+		{"example.com/hot", map[string]string{"time": "2s", "name": "^BenchmarkHot"}},
+		{"example.com/hot/inner", map[string]string{"time": "2s", "name": "^BenchmarkHot", "v": "true"}},
+	}
+	for _, tt := range tests {
+		if got := cfg.packageFlags(tt.pkg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("packageFlags(%q) = %v, want %v", tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	// main defines the flags, which tests do not run.
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	count := fs.Int("count", 10, "")
+	name := fs.String("name", ".", "")
+	quiet := fs.Bool("require-quiet", false, "")
+	old := flag.CommandLine
+	flag.CommandLine = fs
+	defer func() { flag.CommandLine = old }()
+
+	cfg := &projectConfig{
+		file:    ".bench.json",
+		cmdLine: map[string]bool{"name": true},
+		Flags:   map[string]interface{}{"count": 20.0, "name": "^BenchmarkA"},
+		Profiles: map[string]map[string]interface{}{
+			"ci": {"count": 5.0, "require-quiet": true},
+		},
+	}
+	tests := []struct {
+		profile string
+		count   int
+		quiet   bool
+		err     bool
+	}{
+		{"", 20, false, false},
+		// A profile overrides the flags.
+		{"ci", 5, true, false},
+		{"nightly", 10, false, true},
+	}
+	for _, tt := range tests {
+		*count, *name, *quiet = 10, ".", false
+		err := cfg.apply(tt.profile)
+		if (err != nil) != tt.err {
+			t.Errorf("apply(%q): error %v, want error %v", tt.profile, err, tt.err)
+			continue
+		}
+		if *count != tt.count || *quiet != tt.quiet {
+			t.Errorf("apply(%q): count %d, require-quiet %v, want %d, %v", tt.profile, *count, *quiet, tt.count, tt.quiet)
+		}
+		// Flags given on the command line take precedence.
+		if *name != "." {
+			t.Errorf("apply(%q) set -name given on the command line to %q", tt.profile, *name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The golang.design Initiative Authors.
+// All rights reserved. Use of this source code is governed
+// by a GNU GPLv3 license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A toolchain is a local Go installation to run benchmarks with.
+type toolchain struct {
+	name    string // as given to -toolchains
+	goCmd   string // path of its go command
+	goroot  string
+	version string // from go version, such as "go1.22.5 linux/amd64"
+}
+
+// toolchains is the toolchains of -toolchains, or nil if it is not set.
+var toolchains []*toolchain
+
+// findToolchain looks up the toolchain name, which is either a GOROOT
+// directory or the name of a release, such as go1.22.5, installed in
+// ~/sdk or as a command in PATH by golang.org/dl.
+func findToolchain(name string) (*toolchain, error) {
+	t := &toolchain{name: name}
+	var err error
+	if fi, statErr := os.Stat(name); statErr == nil && fi.IsDir() {
+		t.goCmd, err = exec.LookPath(filepath.Join(name, "bin", "go"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: not a GOROOT: %v", name, err)
+		}
+	} else {
+		if home, err := os.UserHomeDir(); err == nil {
+			t.goCmd, _ = exec.LookPath(filepath.Join(home, "sdk", name, "bin", "go"))
+		}
+		if t.goCmd == "" {
+			t.goCmd, err = exec.LookPath(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: toolchain not found in ~/sdk or PATH", name)
+			}
+		}
+	}
+
+	// Ask the toolchain itself, ignoring the GOROOT of the environment,
+	// which belongs to another one.
+	goCmd := func(args ...string) (string, error) {
+		cmd := exec.Command(t.goCmd, args...)
+		for _, kv := range os.Environ() {
+			if !strings.HasPrefix(kv, "GOROOT=") {
+				cmd.Env = append(cmd.Env, kv)
+			}
+		}
+		cmd.Env = append(cmd.Env, "GOTOOLCHAIN=local")
+		out, err := cmd.Output()
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("%s: go %s: %v\n%s", name, strings.Join(args, " "), err, ee.Stderr)
+		} else if err != nil {
+			return "", fmt.Errorf("%s: go %s: %v", name, strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	if t.goroot, err = goCmd("env", "GOROOT"); err != nil {
+		return nil, err
+	}
+	version, err := goCmd("version")
+	if err != nil {
+		return nil, err
+	}
+	t.version = strings.TrimPrefix(version, "go version ")
+	return t, nil
+}
+
+// parseToolchains looks up the comma-separated toolchains of list.
+func parseToolchains(list string) ([]*toolchain, error) {
+	var tcs []*toolchain
+	seen := make(map[string]string) // version to name
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		t, err := findToolchain(name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[t.version]; ok {
+			return nil, fmt.Errorf("%s and %s are the same toolchain %s", other, name, t.version)
+		}
+		seen[t.version] = name
+		tcs = append(tcs, t)
+	}
+	if len(tcs) == 0 {
+		return nil, fmt.Errorf("no toolchains")
+	}
+	return tcs, nil
+}
+
+// env returns the environment variables that make go commands run by
+// the go command of t use t, as name=value.
+func (t *toolchain) env() []string {
+	return []string{
+		"GOROOT=" + t.goroot,
+		// Do not switch to the toolchain required by go.mod.
+		"GOTOOLCHAIN=local",
+		"PATH=" + filepath.Join(t.goroot, "bin") + string(os.PathListSeparator) + os.Getenv("PATH"),
+	}
+}